@@ -2,12 +2,18 @@ package api
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
 	"github.com/Teelevision/telegram-duebelwein-bot/room"
 	"github.com/gorilla/websocket"
 )
@@ -17,9 +23,18 @@ type RoomProvider interface {
 	Room(chatID int64) *room.Room
 }
 
-// Run starts the WebSocket api.
-func Run(roomProvider RoomProvider, listenAddr string) {
-	http.HandleFunc("/", server(roomProvider))
+// FileProvider provides files uploaded to telegram.
+type FileProvider interface {
+	// FileURL returns the url to download the file from. The url must not be
+	// shared, as it contains the bot token.
+	FileURL(fileID string) (string, error)
+}
+
+// Run starts the WebSocket api and the file proxy.
+func Run(roomProvider RoomProvider, fileProvider FileProvider, listenAddr string) {
+	files := &dispatchedFiles{ids: make(map[string]time.Time)}
+	http.HandleFunc("/", server(roomProvider, files))
+	http.HandleFunc("/files/", fileServer(fileProvider, files))
 	err := http.ListenAndServe(listenAddr, nil)
 	if err != nil {
 		panic("ListenAndServe: " + err.Error())
@@ -32,7 +47,7 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-func server(roomProvider RoomProvider) func(http.ResponseWriter, *http.Request) {
+func server(roomProvider RoomProvider, files *dispatchedFiles) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		c, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
@@ -52,6 +67,37 @@ func server(roomProvider RoomProvider) func(http.ResponseWriter, *http.Request)
 			return nil
 		})
 
+		// the connection supports one concurrent writer
+		var writeL sync.Mutex
+		write := func(mt int, message []byte) error {
+			writeL.Lock()
+			defer writeL.Unlock()
+			return c.WriteMessage(mt, message)
+		}
+
+		// tell the player to "skip <chat id>" when the current medium of a
+		// room it plays is skipped, e.g. by vote
+		watched := make(map[*room.Room]func())
+		defer func() {
+			for _, stop := range watched {
+				stop()
+			}
+		}()
+		watchSkips := func(mt int, chatID int64, room *room.Room) {
+			if _, ok := watched[room]; ok {
+				return
+			}
+			skips, stop := room.WatchSkips()
+			watched[room] = stop
+			go func() {
+				for range skips {
+					if err := write(mt, []byte(fmt.Sprintf("skip %d", chatID))); err != nil {
+						log.Println("could not write to websocket:", err)
+					}
+				}
+			}()
+		}
+
 		for {
 			mt, message, err := c.ReadMessage()
 			if err != nil {
@@ -59,9 +105,12 @@ func server(roomProvider RoomProvider) func(http.ResponseWriter, *http.Request)
 				break
 			}
 
-			// handle next messages
-			if len(message) > 5 && string(message[:5]) == "next " {
-				chatID, err := strconv.Atoi(string(message[5:]))
+			// handle messages of the player about a room: "next <chat id>"
+			// when it wants to play the next medium, which finishes the
+			// current one, "playing <chat id>" when it started playing the
+			// current medium and "failed <chat id>" if it could not
+			if cmd, arg := split(string(message)); cmd == "next" || cmd == "playing" || cmd == "failed" {
+				chatID, err := strconv.Atoi(arg)
 				if err != nil {
 					log.Println("could not parse chat id:", err)
 					break
@@ -71,27 +120,43 @@ func server(roomProvider RoomProvider) func(http.ResponseWriter, *http.Request)
 					log.Println("room with chat id not found:", chatID)
 					break
 				}
+				watchSkips(mt, int64(chatID), room)
+				current, hasCurrent := room.Current()
+				if cmd != "next" {
+					if hasCurrent && cmd == "playing" {
+						room.MediumPlaying(current.Medium)
+					} else if hasCurrent {
+						room.MediumFailed(current.Medium)
+					}
+					continue
+				}
+				if hasCurrent {
+					room.MediumPlayed(current.Medium)
+				}
 				go func() {
 					for {
 						if isClosed, ok := closed.Load().(bool); ok && isClosed {
 							return
 						}
 						if queue := room.Queue(); len(queue) > 0 {
-							m := queue[0]
-							msg := []byte(fmt.Sprintf("play %s %v", m.Provider(), m.ID()))
-							err = c.WriteMessage(mt, msg)
+							m := queue[0].Medium
+							md, _ := room.GetMediumMetadata(m)
+							if m.Provider() == medium.ProviderTelegram {
+								files.add(fmt.Sprint(m.ID()))
+							}
+							err := write(mt, playMessage(m, md))
 							if err != nil {
 								log.Println("could not write to websocket:", err)
 								break
 							}
-							room.MediumPlayed(m)
+							room.MediumDispatched(m)
 							break
 						}
 						time.Sleep(time.Second)
 					}
 				}()
 			} else if string(message) == "keep-alive" {
-				err = c.WriteMessage(mt, message)
+				err = write(mt, message)
 				if err != nil {
 					log.Println("could not write to websocket:", err)
 					break
@@ -100,3 +165,120 @@ func server(roomProvider RoomProvider) func(http.ResponseWriter, *http.Request)
 		}
 	}
 }
+
+// split splits the message into the command and its argument.
+func split(message string) (cmd, arg string) {
+	if i := strings.IndexByte(message, ' '); i >= 0 {
+		return message[:i], message[i+1:]
+	}
+	return message, ""
+}
+
+// fileAccessDuration is how long a player may download a telegram file after
+// it was told to play it.
+const fileAccessDuration = 12 * time.Hour
+
+// dispatchedFiles are the ids of the telegram files that were sent to players.
+type dispatchedFiles struct {
+	sync.Mutex
+	ids map[string]time.Time
+}
+
+func (f *dispatchedFiles) add(fileID string) {
+	f.Lock()
+	defer f.Unlock()
+	now := time.Now()
+	f.ids[fileID] = now
+	for id, dispatched := range f.ids {
+		if now.Sub(dispatched) > fileAccessDuration {
+			delete(f.ids, id)
+		}
+	}
+}
+
+func (f *dispatchedFiles) allowed(fileID string) bool {
+	f.Lock()
+	defer f.Unlock()
+	dispatched, ok := f.ids[fileID]
+	return ok && time.Since(dispatched) <= fileAccessDuration
+}
+
+// fileServer proxies the telegram file /files/<file id> to the player, so that
+// the player does not need the bot token. Only files that were sent to a
+// player can be downloaded.
+func fileServer(fileProvider FileProvider, files *dispatchedFiles) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fileID := strings.TrimPrefix(r.URL.Path, "/files/")
+		if !files.allowed(fileID) {
+			http.NotFound(w, r)
+			return
+		}
+		fileURL, err := fileProvider.FileURL(fileID)
+		if err != nil {
+			log.Println("could not get file url:", err)
+			http.Error(w, "file not available", http.StatusBadGateway)
+			return
+		}
+
+		// pass range requests through, so that the player can seek
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, fileURL, nil)
+		if err != nil {
+			log.Println("could not create file request:", err)
+			http.Error(w, "file not available", http.StatusBadGateway)
+			return
+		}
+		if rng := r.Header.Get("Range"); rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Println("could not download file:", err)
+			http.Error(w, "file not available", http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+
+		for _, h := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified"} {
+			if v := resp.Header.Get(h); v != "" {
+				w.Header().Set(h, v)
+			}
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(resp.StatusCode)
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			log.Println("could not proxy file:", err)
+		}
+	}
+}
+
+// playMessage returns the message that tells the player to play the medium:
+// "play <provider> <id>", followed by url encoded parameters if there are any,
+// e.g. "play youtube cNtZAbq2Ig4 end=120&start=95&title=Foo". Offsets and the
+// duration are in seconds.
+func playMessage(m medium.Medium, md metadata.Metadata) []byte {
+	msg := fmt.Sprintf("play %s %v", m.Provider(), m.ID())
+	params := url.Values{}
+	if start, end := medium.Range(m); start != 0 || end != 0 {
+		params.Set("start", formatSeconds(start))
+		if end != 0 {
+			params.Set("end", formatSeconds(end))
+		}
+	}
+	if md.Title != "" {
+		params.Set("title", md.Title)
+	}
+	if md.Artist != "" {
+		params.Set("artist", md.Artist)
+	}
+	if md.Duration > 0 {
+		params.Set("duration", formatSeconds(md.Duration))
+	}
+	if len(params) > 0 {
+		msg += " " + params.Encode()
+	}
+	return []byte(msg)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
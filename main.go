@@ -1,15 +1,86 @@
 package main
 
 import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/Teelevision/telegram-duebelwein-bot/api"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+	"github.com/Teelevision/telegram-duebelwein-bot/room"
+	"github.com/Teelevision/telegram-duebelwein-bot/search"
+	"github.com/Teelevision/telegram-duebelwein-bot/storage"
 	"github.com/Teelevision/telegram-duebelwein-bot/telegram"
 	env "github.com/caarlos0/env/v6"
 )
 
 type config struct {
-	TelegramBotToken  string `env:"TELEGRAM_BOT_TOKEN"`
-	APIListen         string `env:"API_LISTEN" envDefault:":40292"`
-	PlayerURLTemplate string `env:"PLAYER_URL_TEMPLATE"`
+	TelegramBotToken  string        `env:"TELEGRAM_BOT_TOKEN"`
+	APIListen         string        `env:"API_LISTEN" envDefault:":40292"`
+	PlayerURLTemplate string        `env:"PLAYER_URL_TEMPLATE"`
+	YouTubeAPIKey     string        `env:"YOUTUBE_API_KEY"`
+	ExpandPlaylists   bool          `env:"EXPAND_PLAYLISTS"`
+	MaxPlaylistSize   int           `env:"MAX_PLAYLIST_SIZE" envDefault:"20"`
+	OEmbedBaseURL     string        `env:"OEMBED_BASE_URL"`
+	MetadataCacheTTL  time.Duration `env:"METADATA_CACHE_TTL" envDefault:"6h"`
+	SniffFiles        bool          `env:"SNIFF_FILES"`
+	UnwrapShortLinks  bool          `env:"UNWRAP_SHORT_LINKS" envDefault:"false"`
+	ProbableReposts   bool          `env:"DETECT_PROBABLE_REPOSTS" envDefault:"true"`
+	MaxDuration       time.Duration `env:"MAX_DURATION"`
+	MinDuration       time.Duration `env:"MIN_DURATION"`
+	RequireDuration   bool          `env:"REQUIRE_KNOWN_DURATION"`
+	AllowedProviders  []string      `env:"ALLOWED_PROVIDERS"`
+	BlockedLinks      []string      `env:"BLOCKED_LINKS"`
+	BlockedArtists    []string      `env:"BLOCKED_ARTISTS"`
+	BlockedKeywords   []string      `env:"BLOCKED_KEYWORDS"`
+	MaxQueuedPerUser  int           `env:"MAX_QUEUED_PER_USER"`
+	MaxSubmissions    int           `env:"MAX_SUBMISSIONS_PER_USER"`
+	SubmissionWindow  time.Duration `env:"SUBMISSION_WINDOW" envDefault:"1h"`
+	VoteHalfLife      time.Duration `env:"VOTE_HALF_LIFE"`
+	AgingPerHour      float64       `env:"AGING_POINTS_PER_HOUR"`
+	SkipThreshold     string        `env:"SKIP_THRESHOLD" envDefault:"50%"`
+	SkipActiveWindow  time.Duration `env:"SKIP_ACTIVE_WINDOW" envDefault:"1h"`
+	RepostCooldown    time.Duration `env:"REPOST_COOLDOWN" envDefault:"12h"`
+	StorageDir        string        `env:"STORAGE_DIR"`
+}
+
+// policy returns the rules that rooms start with.
+func (cfg config) policy() []room.Rule {
+	var rules []room.Rule
+	if cfg.MaxDuration > 0 {
+		rules = append(rules, room.MaxDuration(cfg.MaxDuration))
+	}
+	if cfg.MinDuration > 0 {
+		rules = append(rules, room.MinDuration(cfg.MinDuration))
+	}
+	if cfg.RequireDuration {
+		rules = append(rules, room.KnownDuration{})
+	}
+	if len(cfg.AllowedProviders) > 0 {
+		rules = append(rules, room.AllowedProviders(cfg.AllowedProviders))
+	}
+	if len(cfg.BlockedLinks) > 0 {
+		blocked := make(room.BlockedMedia, len(cfg.BlockedLinks))
+		for i, link := range cfg.BlockedLinks {
+			m, err := medium.New(link)
+			if err != nil {
+				panic(fmt.Sprintf("blocked link %q: %s", link, err))
+			}
+			blocked[i] = m
+		}
+		rules = append(rules, blocked)
+	}
+	if len(cfg.BlockedArtists) > 0 {
+		rules = append(rules, room.BlockedArtists(cfg.BlockedArtists))
+	}
+	if len(cfg.BlockedKeywords) > 0 {
+		rules = append(rules, room.BlockedKeywords(cfg.BlockedKeywords))
+	}
+	return rules
 }
 
 func main() {
@@ -17,16 +88,67 @@ func main() {
 	if err := env.Parse(&cfg); err != nil {
 		panic(err)
 	}
-
 	// start bot
 	bot, err := telegram.NewBot(cfg.TelegramBotToken, cfg.PlayerURLTemplate)
 	if err != nil {
 		panic(err)
 	}
+	if cfg.ExpandPlaylists && cfg.YouTubeAPIKey != "" {
+		bot.ExpandCollections(&medium.YouTubePlaylistResolver{APIKey: cfg.YouTubeAPIKey}, cfg.MaxPlaylistSize)
+	}
+	if cfg.SniffFiles {
+		bot.SniffFiles()
+	}
+	if cfg.YouTubeAPIKey != "" {
+		bot.Search(&search.YouTube{APIKey: cfg.YouTubeAPIKey})
+	}
+	if cfg.ProbableReposts {
+		bot.DetectProbableReposts()
+	}
+	bot.Policy(cfg.policy()...)
+	bot.Limits(room.Limits{
+		MaxQueued:      cfg.MaxQueuedPerUser,
+		MaxSubmissions: cfg.MaxSubmissions,
+		Window:         cfg.SubmissionWindow,
+	})
+	bot.Aging(room.Aging{HalfLife: cfg.VoteHalfLife, PointsPerHour: cfg.AgingPerHour})
+	skipThreshold, err := room.ParseSkipThreshold(cfg.SkipThreshold)
+	if err != nil {
+		panic(err)
+	}
+	skipThreshold.ActiveWithin = cfg.SkipActiveWindow
+	bot.SkipThreshold(skipThreshold)
+	bot.RepostCooldown(cfg.RepostCooldown)
+	if cfg.UnwrapShortLinks {
+		bot.UnwrapShortLinks(medium.NewUnwrapper(medium.DefaultRedirectors...))
+	}
+	var store *storage.File
+	if cfg.StorageDir != "" {
+		store, err = storage.OpenFile(cfg.StorageDir)
+		if err != nil {
+			panic(err)
+		}
+		bot.Storage(store)
+	}
+	var resolvers []metadata.Resolver
+	if cfg.YouTubeAPIKey != "" {
+		resolvers = append(resolvers, &metadata.YouTube{APIKey: cfg.YouTubeAPIKey})
+	}
+	resolvers = append(resolvers, &metadata.SoundCloud{}, &metadata.OEmbed{BaseURL: cfg.OEmbedBaseURL})
+	bot.ResolveMetadata(metadata.NewCache(metadata.Merged(resolvers...), cfg.MetadataCacheTTL))
 	go bot.Start()
 
 	// start api
-	go api.Run(bot, cfg.APIListen)
+	go api.Run(bot, bot, cfg.APIListen)
 
-	select {} // keep running
+	// keep running until stopped
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	bot.Stop()
+	if store != nil {
+		if err := store.Close(); err != nil {
+			log.Printf("could not close storage: %s", err)
+		}
+	}
 }
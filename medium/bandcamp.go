@@ -0,0 +1,90 @@
+package medium
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ProviderBandcamp is the provider for Bandcamp tracks and albums.
+var ProviderBandcamp = simpleProvider("bandcamp")
+
+func init() {
+	Register(Registration{
+		Provider:        ProviderBandcamp,
+		Hosts:           []string{"*.bandcamp.com"},
+		Parse:           NewBandcampMediumFromURL,
+		ParseCollection: NewBandcampMediumFromURL,
+		ParseID:         NewBandcampMedium,
+	})
+}
+
+// bandcampTrack is the id of a track in the form "artist/track/name".
+type bandcampTrack string
+
+func (m bandcampTrack) Provider() Provider {
+	return ProviderBandcamp
+}
+
+func (m bandcampTrack) ID() interface{} {
+	return string(m)
+}
+
+func (m bandcampTrack) URL() string {
+	return bandcampURL(string(m))
+}
+
+// bandcampAlbum is the id of an album in the form "artist/album/name".
+type bandcampAlbum string
+
+func (m bandcampAlbum) Provider() Provider {
+	return ProviderBandcamp
+}
+
+func (m bandcampAlbum) ID() interface{} {
+	return string(m)
+}
+
+func (m bandcampAlbum) URL() string {
+	return bandcampURL(string(m))
+}
+
+func (m bandcampAlbum) IsCollection() bool {
+	return true
+}
+
+func bandcampURL(id string) string {
+	parts := strings.SplitN(id, "/", 2)
+	return "https://" + parts[0] + ".bandcamp.com/" + parts[1]
+}
+
+var bandcampName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// NewBandcampMedium returns a new medium that is a Bandcamp track or album
+// from its id, e.g. "artist/track/name" or "artist/album/name". Albums are
+// collections.
+func NewBandcampMedium(id string) (Medium, error) {
+	parts := strings.Split(strings.ToLower(id), "/")
+	if len(parts) != 3 || !bandcampName.MatchString(parts[0]) || !bandcampName.MatchString(parts[2]) {
+		return nil, ErrInvalidURL
+	}
+	switch parts[1] {
+	case "track":
+		return bandcampTrack(strings.Join(parts, "/")), nil
+	case "album":
+		return bandcampAlbum(strings.Join(parts, "/")), nil
+	}
+	return nil, ErrInvalidURL
+}
+
+// NewBandcampMediumFromURL returns a new medium that is a Bandcamp track or
+// album from an url like https://artist.bandcamp.com/track/name. Albums are
+// collections. Artist pages are rejected with ErrInvalidURL.
+func NewBandcampMediumFromURL(url *url.URL) (Medium, error) {
+	artist := strings.TrimSuffix(strings.ToLower(url.Hostname()), ".bandcamp.com")
+	parts := strings.Split(strings.Trim(url.Path, "/"), "/")
+	if artist == "www" || len(parts) != 2 {
+		return nil, ErrInvalidURL
+	}
+	return NewBandcampMedium(artist + "/" + strings.Join(parts, "/"))
+}
@@ -0,0 +1,93 @@
+package medium
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Clip is a medium that is only played within a time range. It is identical
+// to the medium it is a clip of.
+type Clip interface {
+	Medium
+	// Start returns the offset the medium starts playing at.
+	Start() time.Duration
+	// End returns the offset the medium stops playing at or 0 if it is played
+	// to its end.
+	End() time.Duration
+}
+
+type clip struct {
+	Medium
+	start, end time.Duration
+}
+
+func (c *clip) Start() time.Duration {
+	return c.start
+}
+
+func (c *clip) End() time.Duration {
+	return c.end
+}
+
+// URL returns the url of the clip if the medium supports clip urls, otherwise
+// the url of the medium.
+func (c *clip) URL() string {
+	if m, ok := c.Medium.(interface {
+		clipURL(start, end time.Duration) string
+	}); ok {
+		return m.clipURL(c.start, c.end)
+	}
+	return URL(c.Medium)
+}
+
+// NewClip returns the medium limited to the given time range. An end of 0
+// plays the medium to its end, as does an end that is not after the start. If
+// the range is empty, the medium is returned as is.
+func NewClip(m Medium, start, end time.Duration) Medium {
+	if c, ok := m.(*clip); ok {
+		m = c.Medium
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end <= start {
+		end = 0
+	}
+	if start == 0 && end == 0 {
+		return m
+	}
+	return &clip{m, start, end}
+}
+
+// Range returns the time range of the medium. Both are 0 if the medium is not
+// a clip.
+func Range(m Medium) (start, end time.Duration) {
+	if c, ok := m.(Clip); ok {
+		return c.Start(), c.End()
+	}
+	return 0, 0
+}
+
+var timestamp = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s?)?$`)
+
+// parseTimestamp parses timestamps like "95", "95s" or "1h1m35s". It returns
+// false if the timestamp is empty or malformed.
+func parseTimestamp(s string) (time.Duration, bool) {
+	match := timestamp.FindStringSubmatch(s)
+	if s == "" || match == nil {
+		return 0, false
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		if match[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(match[i+1])
+		if err != nil {
+			return 0, false
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, true
+}
@@ -0,0 +1,50 @@
+package medium
+
+import (
+	"context"
+	"net/url"
+)
+
+// Collection is a medium that consists of other media, like a playlist. It
+// cannot be played itself but can be expanded by a CollectionResolver.
+type Collection interface {
+	Medium
+	// IsCollection returns whether the medium is a collection.
+	IsCollection() bool
+}
+
+// IsCollection returns whether the medium is a collection.
+func IsCollection(m Medium) bool {
+	c, ok := m.(Collection)
+	return ok && c.IsCollection()
+}
+
+// CollectionResolver resolves the media of collections.
+type CollectionResolver interface {
+	// Resolve returns at most max media of the collection in order. It
+	// returns ErrNotSupported if it cannot resolve this kind of collection.
+	Resolve(ctx context.Context, c Collection, max int) ([]Medium, error)
+}
+
+// NewCollection returns the collection a url refers to, even if the url also
+// refers to a single medium of the collection, like a YouTube video in a
+// playlist. It returns ErrNotSupported if the url does not refer to a
+// collection.
+func NewCollection(rawurl string) (Collection, error) {
+	url, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, ErrNotSupported
+	}
+	r, ok := lookup(url)
+	if !ok || r.ParseCollection == nil {
+		return nil, ErrNotSupported
+	}
+	m, err := r.ParseCollection(url)
+	if err != nil {
+		return nil, err
+	}
+	if !IsCollection(m) {
+		return nil, ErrNotSupported
+	}
+	return m.(Collection), nil
+}
@@ -0,0 +1,8 @@
+package medium
+
+// Unregister removes the provider with the name, so that tests can register
+// it again.
+var Unregister = unregister
+
+// IsPublic reports whether the ip is a public address.
+var IsPublic = isPublic
@@ -0,0 +1,205 @@
+package medium
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned if a file is sniffed on a host that is not
+// publicly reachable.
+var ErrPrivateAddress = errors.New("private address")
+
+// ProviderFile is the provider for audio and video files on the web.
+var ProviderFile = simpleProvider("file")
+
+func init() {
+	Register(Registration{
+		Provider: ProviderFile,
+		Hosts:    []string{"*"},
+		Parse:    NewFileFromURL,
+		ParseID:  NewFile,
+	})
+}
+
+// fileMedium is the normalised url of the file.
+type fileMedium string
+
+func (m fileMedium) Provider() Provider {
+	return ProviderFile
+}
+
+func (m fileMedium) ID() interface{} {
+	return string(m)
+}
+
+func (m fileMedium) URL() string {
+	return string(m)
+}
+
+// fileExtensions are the extensions of files that a browser can play with an
+// audio element.
+var fileExtensions = map[string]bool{
+	".aac": true, ".flac": true, ".m4a": true, ".mp3": true, ".mp4": true,
+	".oga": true, ".ogg": true, ".opus": true, ".wav": true, ".weba": true,
+	".webm": true,
+}
+
+// trackingParams are query parameters that are dropped when normalising urls.
+// Parameters with the prefix "utm_" are dropped, too.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "igshid": true, "mc_cid": true,
+	"mc_eid": true, "ref": true, "ref_src": true, "si": true,
+}
+
+// NewFile returns a new medium that is an audio or video file at the http(s)
+// url. Unlike NewFileFromURL, it trusts the url to be a file regardless of its
+// extension.
+func NewFile(rawurl string) (Medium, error) {
+	url, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	return newFile(url)
+}
+
+// NewFileFromURL returns a new medium that is an audio or video file at the
+// url. It returns ErrNotSupported if the url does not have the extension of a
+// supported file type.
+func NewFileFromURL(url *url.URL) (Medium, error) {
+	if !fileExtensions[strings.ToLower(path.Ext(url.Path))] {
+		return nil, ErrNotSupported
+	}
+	return newFile(url)
+}
+
+func newFile(u *url.URL) (Medium, error) {
+	scheme := strings.ToLower(u.Scheme)
+	if (scheme != "http" && scheme != "https") || u.Hostname() == "" {
+		return nil, ErrInvalidURL
+	}
+
+	// normalise scheme, host and query, drop everything else that does not
+	// identify the file
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(scheme == "http" && port == "80") && !(scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	query := u.Query()
+	for param := range query {
+		if trackingParams[strings.ToLower(param)] || strings.HasPrefix(strings.ToLower(param), "utm_") {
+			query.Del(param)
+		}
+	}
+	normalised := url.URL{
+		Scheme:   scheme,
+		Host:     host,
+		Path:     u.Path,
+		RawPath:  u.RawPath,
+		RawQuery: query.Encode(),
+	}
+	return fileMedium(normalised.String()), nil
+}
+
+// SniffFile returns a new medium that is an audio or video file at the url if
+// the url serves one according to its Content-Type, regardless of its
+// extension. It returns ErrNotSupported otherwise. A nil client defaults to
+// PublicClient, so that links cannot make the bot reach into private
+// networks.
+func SniffFile(ctx context.Context, client *http.Client, rawurl string) (Medium, error) {
+	url, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	if m, err := NewFileFromURL(url); err == nil {
+		return m, nil
+	}
+	m, err := newFile(url)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = PublicClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrNotSupported
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "audio/") && !strings.HasPrefix(mediaType, "video/") && mediaType != "application/ogg" {
+		return nil, ErrNotSupported
+	}
+	return m, nil
+}
+
+// PublicClient is an http client that only connects to public addresses. It
+// refuses loopback, private, link-local and other special addresses, even
+// after redirects, and does not use a proxy.
+var PublicClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+					return ErrPrivateAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// nonPublicNetworks are the networks besides loopback, link-local and
+// multicast addresses that are not publicly reachable.
+var nonPublicNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12",
+		"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "240.0.0.0/4",
+		"64:ff9b::/96", "fc00::/7",
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// isPublic returns whether the ip is a publicly reachable unicast address.
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
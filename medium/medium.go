@@ -21,9 +21,8 @@ func New(rawurl string) (Medium, error) {
 		return nil, ErrNotSupported
 	}
 
-	switch url.Host {
-	case "youtube.com", "youtu.be", "www.youtube.com":
-		return NewYouTubeVideoFromURL(url)
+	if r, ok := lookup(url); ok {
+		return r.Parse(url)
 	}
 	log.Println(url.Host, url.Path)
 
@@ -1,8 +1,17 @@
 package medium_test
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/Teelevision/telegram-duebelwein-bot/medium"
 )
@@ -39,6 +48,271 @@ func TestNew(t *testing.T) {
 			rawurl: "https://www.youtube.com/watch?v=jZya02M_caU&list=PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D",
 			err:    nil,
 			medium: youTubeVideo("jZya02M_caU"),
+		}, {
+			desc:   "youtube pure playlist url",
+			rawurl: "https://www.youtube.com/playlist?list=PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D",
+			err:    nil,
+			medium: youTubePlaylist("PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D"),
+		}, {
+			desc:   "youtube embedded playlist url",
+			rawurl: "https://www.youtube.com/embed/videoseries?list=PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D",
+			err:    nil,
+			medium: youTubePlaylist("PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D"),
+		}, {
+			desc:   "youtube url without www",
+			rawurl: "https://youtube.com/watch?v=cNtZAbq2Ig4",
+			err:    nil,
+			medium: youTubeVideo("cNtZAbq2Ig4"),
+		}, {
+			desc:   "youtube mobile url",
+			rawurl: "https://m.youtube.com/watch?v=cNtZAbq2Ig4&feature=share",
+			err:    nil,
+			medium: youTubeVideo("cNtZAbq2Ig4"),
+		}, {
+			desc:   "youtube music url",
+			rawurl: "https://music.youtube.com/watch?v=cNtZAbq2Ig4&si=foo",
+			err:    nil,
+			medium: youTubeVideo("cNtZAbq2Ig4"),
+		}, {
+			desc:   "youtube short url with tracking param",
+			rawurl: "https://youtu.be/YgGzAKP_HuM?si=AbCdEfGh",
+			err:    nil,
+			medium: youTubeVideo("YgGzAKP_HuM"),
+		}, {
+			desc:   "youtube shorts url",
+			rawurl: "https://www.youtube.com/shorts/YgGzAKP_HuM",
+			err:    nil,
+			medium: youTubeVideo("YgGzAKP_HuM"),
+		}, {
+			desc:   "youtube embed url",
+			rawurl: "https://www.youtube.com/embed/YgGzAKP_HuM?autoplay=1",
+			err:    nil,
+			medium: youTubeVideo("YgGzAKP_HuM"),
+		}, {
+			desc:   "youtube nocookie embed url",
+			rawurl: "https://www.youtube-nocookie.com/embed/YgGzAKP_HuM",
+			err:    nil,
+			medium: youTubeVideo("YgGzAKP_HuM"),
+		}, {
+			desc:   "youtube live url",
+			rawurl: "https://www.youtube.com/live/YgGzAKP_HuM?feature=share",
+			err:    nil,
+			medium: youTubeVideo("YgGzAKP_HuM"),
+		}, {
+			desc:   "youtube v url",
+			rawurl: "https://www.youtube.com/v/YgGzAKP_HuM?version=3",
+			err:    nil,
+			medium: youTubeVideo("YgGzAKP_HuM"),
+		}, {
+			desc:   "youtube attribution link",
+			rawurl: "https://www.youtube.com/attribution_link?a=foo&u=%2Fwatch%3Fv%3DYgGzAKP_HuM%26feature%3Dshare",
+			err:    nil,
+			medium: youTubeVideo("YgGzAKP_HuM"),
+		}, {
+			desc:   "youtube channel url",
+			rawurl: "https://www.youtube.com/channel/UCxxxxxxxxxxxxxxxxxxxxxx",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "youtube handle url",
+			rawurl: "https://www.youtube.com/@someverylongchannelname",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "youtube url with too short id",
+			rawurl: "https://www.youtube.com/watch?v=cNtZAbq2Ig",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "youtube url with too long id",
+			rawurl: "https://www.youtube.com/watch?v=cNtZAbq2Ig4x",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "youtube url with invalid characters in id",
+			rawurl: "https://www.youtube.com/watch?v=cNtZAbq2Ig!",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "youtube short url with path",
+			rawurl: "https://youtu.be/YgGzAKP_HuM/foo",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "youtube shorts url without id",
+			rawurl: "https://www.youtube.com/shorts/",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "soundcloud track",
+			rawurl: "https://soundcloud.com/fu-ggbeats/sludge",
+			err:    nil,
+			medium: soundCloud("fu-ggbeats/sludge"),
+		}, {
+			desc:   "soundcloud track with tracking params and mixed case",
+			rawurl: "https://www.soundcloud.com/Fu-GGbeats/Sludge/?utm_source=clipboard&in=foo/sets/bar",
+			err:    nil,
+			medium: soundCloud("fu-ggbeats/sludge"),
+		}, {
+			desc:   "soundcloud mobile track",
+			rawurl: "https://m.soundcloud.com/fu-ggbeats/sludge",
+			err:    nil,
+			medium: soundCloud("fu-ggbeats/sludge"),
+		}, {
+			desc:   "soundcloud track sub page",
+			rawurl: "https://soundcloud.com/fu-ggbeats/sludge/comments",
+			err:    nil,
+			medium: soundCloud("fu-ggbeats/sludge"),
+		}, {
+			desc:   "soundcloud private track",
+			rawurl: "https://soundcloud.com/fu-ggbeats/sludge/s-AbC123",
+			err:    nil,
+			medium: soundCloud("fu-ggbeats/sludge/s-AbC123"),
+		}, {
+			desc:   "soundcloud set",
+			rawurl: "https://soundcloud.com/fu-ggbeats/sets/dancefloor",
+			err:    nil,
+			medium: soundCloud("fu-ggbeats/sets/dancefloor"),
+		}, {
+			desc:   "soundcloud short link",
+			rawurl: "https://on.soundcloud.com/Ab12Cd",
+			err:    nil,
+			medium: soundCloud("on/Ab12Cd"),
+		}, {
+			desc:   "soundcloud user page",
+			rawurl: "https://soundcloud.com/fu-ggbeats",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "soundcloud profile page",
+			rawurl: "https://soundcloud.com/fu-ggbeats/tracks",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "soundcloud sets page",
+			rawurl: "https://soundcloud.com/fu-ggbeats/sets",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "soundcloud discover page",
+			rawurl: "https://soundcloud.com/discover/sets",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "spotify track url",
+			rawurl: "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC",
+			err:    nil,
+			medium: spotifyTrack("4uLU6hMCjMI75M1A2tKUQC"),
+		}, {
+			desc:   "spotify track url with tracking param",
+			rawurl: "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC?si=a1b2c3d4e5f64a7b",
+			err:    nil,
+			medium: spotifyTrack("4uLU6hMCjMI75M1A2tKUQC"),
+		}, {
+			desc:   "spotify localised track url",
+			rawurl: "https://open.spotify.com/intl-de/track/4uLU6hMCjMI75M1A2tKUQC?si=a1b2c3d4e5f64a7b",
+			err:    nil,
+			medium: spotifyTrack("4uLU6hMCjMI75M1A2tKUQC"),
+		}, {
+			desc:   "spotify embed url",
+			rawurl: "https://open.spotify.com/embed/track/4uLU6hMCjMI75M1A2tKUQC",
+			err:    nil,
+			medium: spotifyTrack("4uLU6hMCjMI75M1A2tKUQC"),
+		}, {
+			desc:   "spotify track uri",
+			rawurl: "spotify:track:4uLU6hMCjMI75M1A2tKUQC",
+			err:    nil,
+			medium: spotifyTrack("4uLU6hMCjMI75M1A2tKUQC"),
+		}, {
+			desc:   "spotify album url",
+			rawurl: "https://open.spotify.com/album/1DFixLWuPkv3KT3TnV35m3",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "spotify album uri",
+			rawurl: "spotify:album:1DFixLWuPkv3KT3TnV35m3",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "spotify track url with invalid id",
+			rawurl: "https://open.spotify.com/track/foobar",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "bandcamp track",
+			rawurl: "https://Sabaton.bandcamp.com/track/night-witches?from=embed",
+			err:    nil,
+			medium: bandcamp("sabaton/track/night-witches"),
+		}, {
+			desc:   "bandcamp album",
+			rawurl: "https://sabaton.bandcamp.com/album/heroes/",
+			err:    nil,
+			medium: bandcamp("sabaton/album/heroes"),
+		}, {
+			desc:   "bandcamp artist page",
+			rawurl: "https://sabaton.bandcamp.com/",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "bandcamp music page",
+			rawurl: "https://sabaton.bandcamp.com/music",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "bandcamp merch page",
+			rawurl: "https://sabaton.bandcamp.com/merch/shirt",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "mixcloud show",
+			rawurl: "https://www.mixcloud.com/DaTweekaz/hardstyle-mix-2019/",
+			err:    nil,
+			medium: mixcloud("datweekaz/hardstyle-mix-2019"),
+		}, {
+			desc:   "mixcloud mobile show without trailing slash",
+			rawurl: "https://m.mixcloud.com/datweekaz/hardstyle-mix-2019?utm_source=widget",
+			err:    nil,
+			medium: mixcloud("datweekaz/hardstyle-mix-2019"),
+		}, {
+			desc:   "mixcloud user page",
+			rawurl: "https://www.mixcloud.com/datweekaz/",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "mixcloud profile page",
+			rawurl: "https://www.mixcloud.com/datweekaz/favorites/",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "mixcloud discover page",
+			rawurl: "https://www.mixcloud.com/discover/hardstyle/",
+			err:    ErrInvalidURL,
+			medium: nil,
+		}, {
+			desc:   "audio file",
+			rawurl: "https://mixes.example.org/2019/Dübelwein%20Mix.mp3",
+			err:    nil,
+			medium: file("https://mixes.example.org/2019/D%C3%BCbelwein%20Mix.mp3"),
+		}, {
+			desc:   "audio file with tracking params, port and fragment",
+			rawurl: "HTTPS://Mixes.Example.org:443/mix.OGG?utm_source=telegram&token=abc&fbclid=123&a=1#intro",
+			err:    nil,
+			medium: file("https://mixes.example.org/mix.OGG?a=1&token=abc"),
+		}, {
+			desc:   "video file on non default port",
+			rawurl: "http://192.168.1.2:8080/videos/party.webm",
+			err:    nil,
+			medium: file("http://192.168.1.2:8080/videos/party.webm"),
+		}, {
+			desc:   "unknown host without file",
+			rawurl: "https://example.org/party.html",
+			err:    ErrNotSupported,
+			medium: nil,
+		}, {
+			desc:   "audio file with unsupported scheme",
+			rawurl: "ftp://example.org/party.mp3",
+			err:    ErrInvalidURL,
+			medium: nil,
 		},
 	}
 	for _, tC := range testCases {
@@ -54,8 +328,541 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestRoundTrip(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		rawurl string
+		url    string
+		uri    string
+	}{
+		{
+			desc:   "youtube video",
+			rawurl: "https://youtu.be/YgGzAKP_HuM?si=foo",
+			url:    "https://www.youtube.com/watch?v=YgGzAKP_HuM",
+			uri:    "youtube:YgGzAKP_HuM",
+		}, {
+			desc:   "youtube clip",
+			rawurl: "https://youtu.be/YgGzAKP_HuM?t=1m35s",
+			url:    "https://www.youtube.com/watch?v=YgGzAKP_HuM&t=95s",
+			uri:    "youtube:YgGzAKP_HuM",
+		}, {
+			desc:   "youtube clip with end",
+			rawurl: "https://www.youtube.com/embed/YgGzAKP_HuM?start=30&end=90",
+			url:    "https://www.youtube.com/embed/YgGzAKP_HuM?start=30&end=90",
+			uri:    "youtube:YgGzAKP_HuM",
+		}, {
+			desc:   "youtube playlist",
+			rawurl: "https://www.youtube.com/embed/videoseries?list=PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D",
+			url:    "https://www.youtube.com/playlist?list=PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D",
+			uri:    "youtube:PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D",
+		}, {
+			desc:   "soundcloud track",
+			rawurl: "https://m.soundcloud.com/Fu-GGbeats/Sludge",
+			url:    "https://soundcloud.com/fu-ggbeats/sludge",
+			uri:    "soundcloud:fu-ggbeats/sludge",
+		}, {
+			desc:   "soundcloud short link",
+			rawurl: "https://on.soundcloud.com/Ab12Cd",
+			url:    "https://on.soundcloud.com/Ab12Cd",
+			uri:    "soundcloud:on/Ab12Cd",
+		}, {
+			desc:   "spotify track",
+			rawurl: "spotify:track:4uLU6hMCjMI75M1A2tKUQC",
+			url:    "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC",
+			uri:    "spotify:4uLU6hMCjMI75M1A2tKUQC",
+		}, {
+			desc:   "bandcamp album",
+			rawurl: "https://sabaton.bandcamp.com/album/heroes",
+			url:    "https://sabaton.bandcamp.com/album/heroes",
+			uri:    "bandcamp:sabaton/album/heroes",
+		}, {
+			desc:   "mixcloud show",
+			rawurl: "https://m.mixcloud.com/DaTweekaz/hardstyle-mix-2019",
+			url:    "https://www.mixcloud.com/datweekaz/hardstyle-mix-2019/",
+			uri:    "mixcloud:datweekaz/hardstyle-mix-2019",
+		}, {
+			desc:   "file",
+			rawurl: "https://mixes.example.org/mix.mp3?utm_source=foo",
+			url:    "https://mixes.example.org/mix.mp3",
+			uri:    "file:https://mixes.example.org/mix.mp3",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			m, err := New(tC.rawurl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start, end := Range(m)
+
+			// url
+			if url := URL(m); url != tC.url {
+				t.Errorf("expected url %q, got %q", tC.url, url)
+			}
+			fromURL, err := New(URL(m))
+			if err != nil {
+				t.Fatalf("did not expect error parsing the url, got %q", err)
+			}
+			if s, e := Range(fromURL); !Identical(m, fromURL) || s != start || e != end {
+				t.Errorf("expected %#v from url, got %#v", m, fromURL)
+			}
+
+			// uri
+			if uri := URI(m); uri != tC.uri {
+				t.Errorf("expected uri %q, got %q", tC.uri, uri)
+			}
+			fromURI, err := ParseURI(URI(m))
+			if err != nil {
+				t.Fatalf("did not expect error parsing the uri, got %q", err)
+			}
+			if !Identical(m, fromURI) || IsCollection(m) != IsCollection(fromURI) {
+				t.Errorf("expected %#v from uri, got %#v", m, fromURI)
+			}
+
+			// json
+			data, err := json.Marshal(JSON{m})
+			if err != nil {
+				t.Fatalf("did not expect error marshalling, got %q", err)
+			}
+			var fromJSON JSON
+			if err := json.Unmarshal(data, &fromJSON); err != nil {
+				t.Fatalf("did not expect error unmarshalling %s, got %q", data, err)
+			}
+			if s, e := Range(fromJSON.Medium); !Identical(m, fromJSON.Medium) || s != start || e != end {
+				t.Errorf("expected %#v from json %s, got %#v", m, data, fromJSON.Medium)
+			}
+		})
+	}
+
+	t.Run("telegram file uri", func(t *testing.T) {
+		m, err := ParseURI("telegram:CQACAgIAAxkBAAIBY2Xz-_abc")
+		if err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+		if m.Provider() != ProviderTelegram || m.ID() != "CQACAgIAAxkBAAIBY2Xz-_abc" || URL(m) != "" {
+			t.Errorf("expected telegram file without url, got %#v", m)
+		}
+	})
+
+	t.Run("json null", func(t *testing.T) {
+		data, err := json.Marshal(struct{ M JSON }{})
+		if err != nil || string(data) != `{"M":null}` {
+			t.Fatalf("expected null, got %s (%v)", data, err)
+		}
+	})
+
+	for _, uri := range []string{"foobar", "unknown:1234", "youtube:foo", "spotify:", "telegram:a/b"} {
+		t.Run("invalid uri "+uri, func(t *testing.T) {
+			if m, err := ParseURI(uri); err == nil {
+				t.Errorf("expected error, got %#v", m)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register(Registration{
+		Provider: fooProvider{},
+		Hosts:    []string{"foo.example", "*.foo.example"},
+		Parse: func(url *url.URL) (Medium, error) {
+			id := strings.Trim(url.Path, "/")
+			if id == "" {
+				return nil, ErrInvalidURL
+			}
+			return &someMedium{fooProvider{}, id}, nil
+		},
+	})
+	defer Unregister(fooProvider{}.String())
+
+	testCases := []struct {
+		desc   string
+		rawurl string
+		err    error
+		medium Medium
+	}{
+		{
+			desc:   "exact host",
+			rawurl: "https://foo.example/abc",
+			medium: &someMedium{fooProvider{}, "abc"},
+		}, {
+			desc:   "sub domain",
+			rawurl: "https://www.foo.example/abc",
+			medium: &someMedium{fooProvider{}, "abc"},
+		}, {
+			desc:   "host with port and upper case",
+			rawurl: "https://FOO.example:8080/abc",
+			medium: &someMedium{fooProvider{}, "abc"},
+		}, {
+			desc:   "parser error",
+			rawurl: "https://foo.example/",
+			err:    ErrInvalidURL,
+		}, {
+			desc:   "similar host",
+			rawurl: "https://barfoo.example/abc",
+			err:    ErrNotSupported,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			m, err := New(tC.rawurl)
+			if !errors.Is(err, tC.err) {
+				t.Fatalf("got error %q, expected %q", err, tC.err)
+			}
+			if !Identical(m, tC.medium) {
+				t.Errorf("expected medium %#v, got %#v.", tC.medium, m)
+			}
+		})
+	}
+
+	t.Run("listed in providers", func(t *testing.T) {
+		var found bool
+		for _, p := range Providers() {
+			found = found || p == fooProvider{}
+		}
+		if !found {
+			t.Errorf("expected %q in providers %v", fooProvider{}, Providers())
+		}
+	})
+
+	t.Run("twice", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected registering a provider twice to panic")
+			}
+		}()
+		Register(Registration{Provider: ProviderYouTube, Parse: NewYouTubeVideoFromURL})
+	})
+}
+
+func TestNewCollection(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		rawurl     string
+		err        error
+		collection Medium
+	}{
+		{
+			desc:       "youtube playlist url",
+			rawurl:     "https://www.youtube.com/playlist?list=PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D",
+			collection: youTubePlaylist("PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D"),
+		}, {
+			desc:       "youtube video in playlist url",
+			rawurl:     "https://www.youtube.com/watch?v=jZya02M_caU&list=PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D",
+			collection: youTubePlaylist("PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D"),
+		}, {
+			desc:   "youtube video url",
+			rawurl: "https://www.youtube.com/watch?v=jZya02M_caU",
+			err:    ErrInvalidURL,
+		}, {
+			desc:       "bandcamp album url",
+			rawurl:     "https://sabaton.bandcamp.com/album/heroes",
+			collection: bandcamp("sabaton/album/heroes"),
+		}, {
+			desc:   "bandcamp track url",
+			rawurl: "https://sabaton.bandcamp.com/track/night-witches",
+			err:    ErrNotSupported,
+		}, {
+			desc:   "provider without collections",
+			rawurl: "https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC",
+			err:    ErrNotSupported,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			c, err := NewCollection(tC.rawurl)
+			if !errors.Is(err, tC.err) {
+				t.Fatalf("got error %q, expected %q", err, tC.err)
+			}
+			if tC.collection == nil {
+				return
+			}
+			if !Identical(c, tC.collection) || !IsCollection(c) {
+				t.Errorf("expected collection %#v, got %#v.", tC.collection, c)
+			}
+		})
+	}
+}
+
+func TestYouTubePlaylistResolver(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/playlistItems" || r.URL.Query().Get("key") != "secret" ||
+			r.URL.Query().Get("playlistId") != "PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Query().Get("pageToken") {
+		case "":
+			fmt.Fprint(w, `{"nextPageToken": "page2", "items": [
+				{"contentDetails": {"videoId": "cNtZAbq2Ig4"}},
+				{"contentDetails": {"videoId": "deleted"}}
+			]}`)
+		case "page2":
+			fmt.Fprint(w, `{"items": [
+				{"contentDetails": {"videoId": "YgGzAKP_HuM"}},
+				{"contentDetails": {"videoId": "jZya02M_caU"}}
+			]}`)
+		}
+	}))
+	defer server.Close()
+
+	resolver := &YouTubePlaylistResolver{APIKey: "secret", BaseURL: server.URL}
+	playlist, _ := NewYouTubePlaylist("PL81aLNZD3wMVLx-weUkf_un7MOHFnD08D")
+
+	media, err := resolver.Resolve(context.Background(), playlist, 2)
+	if err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	if len(media) != 2 || !Identical(media[0], youTubeVideo("cNtZAbq2Ig4")) || !Identical(media[1], youTubeVideo("YgGzAKP_HuM")) {
+		t.Errorf("expected the first two available videos, got %v", media)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+
+	if _, err := resolver.Resolve(context.Background(), &someCollection{}, 2); err != ErrNotSupported {
+		t.Errorf("expected error %q for other collections, got %q", ErrNotSupported, err)
+	}
+}
+
+func TestSniffFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.URL.Path {
+		case "/stream":
+			w.Header().Set("Content-Type", "audio/mpeg")
+		case "/radio":
+			w.Header().Set("Content-Type", "application/ogg; charset=binary")
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		desc string
+		path string
+		err  error
+	}{
+		{desc: "audio", path: "/stream?utm_source=foo", err: nil},
+		{desc: "ogg", path: "/radio", err: nil},
+		{desc: "extension", path: "/missing.mp3", err: nil},
+		{desc: "html", path: "/page", err: ErrNotSupported},
+		{desc: "not found", path: "/missing", err: ErrNotSupported},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			m, err := SniffFile(context.Background(), server.Client(), server.URL+tC.path)
+			if err != tC.err {
+				t.Fatalf("got error %q, expected %q", err, tC.err)
+			}
+			if err == nil && m.Provider() != ProviderFile {
+				t.Errorf("expected file, got %#v", m)
+			}
+		})
+	}
+
+	t.Run("normalised", func(t *testing.T) {
+		m, _ := SniffFile(context.Background(), server.Client(), server.URL+"/stream?utm_source=foo#bar")
+		if want := file(server.URL + "/stream"); !Identical(m, want) {
+			t.Errorf("expected %#v, got %#v", want, m)
+		}
+	})
+
+	t.Run("private", func(t *testing.T) {
+		_, err := SniffFile(context.Background(), nil, server.URL+"/stream")
+		if !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("expected error %q, got %q", ErrPrivateAddress, err)
+		}
+	})
+}
+
+func TestIsPublic(t *testing.T) {
+	testCases := []struct {
+		ip     string
+		public bool
+	}{
+		{ip: "93.184.216.34", public: true},
+		{ip: "2606:2800:220:1:248:1893:25c8:1946", public: true},
+		{ip: "127.0.0.1", public: false},
+		{ip: "::1", public: false},
+		{ip: "::ffff:127.0.0.1", public: false},
+		{ip: "0.0.0.0", public: false},
+		{ip: "10.1.2.3", public: false},
+		{ip: "172.20.0.1", public: false},
+		{ip: "192.168.178.1", public: false},
+		{ip: "100.64.0.1", public: false},
+		{ip: "169.254.169.254", public: false},
+		{ip: "fe80::1", public: false},
+		{ip: "fd00::1", public: false},
+		{ip: "224.0.0.1", public: false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.ip, func(t *testing.T) {
+			if public := IsPublic(net.ParseIP(tC.ip)); public != tC.public {
+				t.Errorf("expected %t, got %t", tC.public, public)
+			}
+		})
+	}
+}
+
+func TestUnwrapper(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			http.Redirect(w, r, "/c?x=1", http.StatusFound)
+		case "/c":
+			http.Redirect(w, r, "https://youtu.be/YgGzAKP_HuM?si=foo", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+			http.Redirect(w, r, "/c", http.StatusFound)
+		default:
+			fmt.Fprint(w, "no redirect")
+		}
+	}))
+	defer server.Close()
+	newUnwrapper := func() *Unwrapper {
+		u := NewUnwrapper("127.0.0.1")
+		u.Client.Transport = server.Client().Transport
+		return u
+	}
+
+	t.Run("redirect chain", func(t *testing.T) {
+		requests = 0
+		u := newUnwrapper()
+		target, err := u.Unwrap(context.Background(), server.URL+"/a")
+		if err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+		if want := "https://youtu.be/YgGzAKP_HuM?si=foo"; target != want {
+			t.Errorf("expected %q, got %q", want, target)
+		}
+		if requests != 3 {
+			t.Errorf("expected 3 requests, got %d", requests)
+		}
+
+		// the target is cached
+		if target, err := u.Unwrap(context.Background(), server.URL+"/a"); err != nil || target != "https://youtu.be/YgGzAKP_HuM?si=foo" {
+			t.Errorf("expected cached target, got %q (%v)", target, err)
+		}
+		if requests != 3 {
+			t.Errorf("expected no further requests, got %d", requests-3)
+		}
+	})
+
+	t.Run("no redirect", func(t *testing.T) {
+		target, err := newUnwrapper().Unwrap(context.Background(), server.URL+"/page")
+		if err != nil || target != server.URL+"/page" {
+			t.Errorf("expected link itself, got %q (%v)", target, err)
+		}
+	})
+
+	t.Run("not a redirector", func(t *testing.T) {
+		requests = 0
+		target, err := newUnwrapper().Unwrap(context.Background(), "https://example.org/a")
+		if err != nil || target != "https://example.org/a" || requests != 0 {
+			t.Errorf("expected link itself without requests, got %q (%v)", target, err)
+		}
+	})
+
+	t.Run("hop limit", func(t *testing.T) {
+		u := newUnwrapper()
+		u.MaxHops = 2
+		if _, err := u.Unwrap(context.Background(), server.URL+"/a"); err != ErrTooManyRedirects {
+			t.Errorf("expected error %q, got %q", ErrTooManyRedirects, err)
+		}
+		if _, err := u.Unwrap(context.Background(), server.URL+"/loop"); err != ErrTooManyRedirects {
+			t.Errorf("expected error %q, got %q", ErrTooManyRedirects, err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		u := newUnwrapper()
+		u.Timeout = 10 * time.Millisecond
+		if _, err := u.Unwrap(context.Background(), server.URL+"/slow"); err == nil {
+			t.Error("expected timeout error")
+		}
+	})
+
+	t.Run("private address", func(t *testing.T) {
+		for _, u := range []*Unwrapper{NewUnwrapper("127.0.0.1"), {Hosts: []string{"127.0.0.1"}}} {
+			if _, err := u.Unwrap(context.Background(), server.URL+"/a"); !errors.Is(err, ErrPrivateAddress) {
+				t.Errorf("expected error %q, got %q", ErrPrivateAddress, err)
+			}
+		}
+	})
+}
+
+func TestRange(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		rawurl     string
+		start, end time.Duration
+	}{
+		{
+			desc:   "no range",
+			rawurl: "https://youtu.be/YgGzAKP_HuM",
+		}, {
+			desc:   "t in seconds",
+			rawurl: "https://youtu.be/YgGzAKP_HuM?t=95",
+			start:  95 * time.Second,
+		}, {
+			desc:   "t with units",
+			rawurl: "https://www.youtube.com/watch?v=cNtZAbq2Ig4&t=1m35s",
+			start:  95 * time.Second,
+		}, {
+			desc:   "t with hours",
+			rawurl: "https://www.youtube.com/watch?v=cNtZAbq2Ig4&t=1h2m3s",
+			start:  time.Hour + 2*time.Minute + 3*time.Second,
+		}, {
+			desc:   "t in fragment",
+			rawurl: "https://www.youtube.com/watch?v=cNtZAbq2Ig4#t=1m35s",
+			start:  95 * time.Second,
+		}, {
+			desc:   "start and end",
+			rawurl: "https://www.youtube.com/embed/YgGzAKP_HuM?start=30&end=90",
+			start:  30 * time.Second,
+			end:    90 * time.Second,
+		}, {
+			desc:   "only end",
+			rawurl: "https://www.youtube.com/embed/YgGzAKP_HuM?end=90",
+			end:    90 * time.Second,
+		}, {
+			desc:   "end before start",
+			rawurl: "https://www.youtube.com/embed/YgGzAKP_HuM?start=90&end=30",
+			start:  90 * time.Second,
+		}, {
+			desc:   "malformed t",
+			rawurl: "https://youtu.be/YgGzAKP_HuM?t=soon",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			m, err := New(tC.rawurl)
+			if err != nil {
+				t.Fatalf("did not expect error, got %q", err)
+			}
+			if start, end := Range(m); start != tC.start || end != tC.end {
+				t.Errorf("expected range %s-%s, got %s-%s", tC.start, tC.end, start, end)
+			}
+		})
+	}
+}
+
 func TestIdentical(t *testing.T) {
-	ytVid := youTubeVideo("foobar")
+	ytVid := youTubeVideo("cNtZAbq2Ig4")
 
 	testCases := []struct {
 		desc   string
@@ -87,6 +894,16 @@ func TestIdentical(t *testing.T) {
 			a:      &someMedium{fooProvider{}, 1111},
 			b:      &someMedium{fooProvider{}, 1111},
 			result: true,
+		}, {
+			desc:   "clips of same media",
+			a:      NewClip(ytVid, 10*time.Second, 0),
+			b:      NewClip(ytVid, 20*time.Second, 30*time.Second),
+			result: true,
+		}, {
+			desc:   "clip and its media",
+			a:      NewClip(ytVid, 10*time.Second, 0),
+			b:      ytVid,
+			result: true,
 		}, {
 			desc:   "one nil",
 			a:      &someMedium{fooProvider{}, 1111},
@@ -111,6 +928,54 @@ func youTubeVideo(videoID string) Medium {
 	return m
 }
 
+func youTubePlaylist(playlistID string) Medium {
+	m, err := NewYouTubePlaylist(playlistID)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func bandcamp(id string) Medium {
+	m, err := NewBandcampMedium(id)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func mixcloud(id string) Medium {
+	m, err := NewMixcloudShow(id)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func file(url string) Medium {
+	m, err := NewFile(url)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func soundCloud(path string) Medium {
+	m, err := NewSoundCloudMedium(path)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func spotifyTrack(trackID string) Medium {
+	m, err := NewSpotifyTrack(trackID)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
 type fooProvider struct{}
 
 func (p fooProvider) String() string {
@@ -135,3 +1000,11 @@ func (m *someMedium) Provider() Provider {
 func (m *someMedium) ID() interface{} {
 	return m.id
 }
+
+type someCollection struct {
+	someMedium
+}
+
+func (c *someCollection) IsCollection() bool {
+	return true
+}
@@ -0,0 +1,74 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+)
+
+// Cache is a resolver that caches the metadata resolved by another resolver
+// in memory. Errors are not cached.
+type Cache struct {
+	resolver Resolver
+	ttl      time.Duration
+	now      func() time.Time
+
+	l       sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+type cacheKey struct {
+	provider string
+	id       string
+}
+
+type cacheEntry struct {
+	metadata Metadata
+	expires  time.Time
+}
+
+// NewCache returns a cache that keeps metadata for the ttl.
+func NewCache(resolver Resolver, ttl time.Duration) *Cache {
+	return newCache(resolver, ttl, time.Now)
+}
+
+func newCache(resolver Resolver, ttl time.Duration, now func() time.Time) *Cache {
+	return &Cache{
+		resolver: resolver,
+		ttl:      ttl,
+		now:      now,
+		entries:  make(map[cacheKey]cacheEntry),
+	}
+}
+
+// Resolve returns the cached metadata of the medium or resolves it.
+func (c *Cache) Resolve(ctx context.Context, m medium.Medium) (Metadata, error) {
+	key := cacheKey{m.Provider().String(), fmt.Sprint(m.ID())}
+	c.l.Lock()
+	entry, ok := c.entries[key]
+	c.l.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.metadata, nil
+	}
+
+	// resolve without holding the lock
+	md, err := c.resolver.Resolve(ctx, m)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	now := c.now()
+	c.entries[key] = cacheEntry{md, now.Add(c.ttl)}
+	// drop expired entries
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	return md, nil
+}
@@ -0,0 +1,4 @@
+package metadata
+
+// NewCacheWithClock returns a cache that uses the clock instead of time.Now.
+var NewCacheWithClock = newCache
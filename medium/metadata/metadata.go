@@ -0,0 +1,131 @@
+// Package metadata resolves information about media, like their title.
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+)
+
+// Metadata is information about a medium. All fields are optional.
+type Metadata struct {
+	Title  string
+	Artist string
+	// Duration is the length of the whole medium, regardless of clips.
+	Duration  time.Duration
+	Thumbnail string
+}
+
+// String returns the title of the medium including the artist and duration
+// if they are known, e.g. "Sabaton - Night Witches (3:03)". It is empty if
+// the title is unknown.
+func (md Metadata) String() string {
+	if md.Title == "" {
+		return ""
+	}
+	s := md.Title
+	if md.Artist != "" {
+		s = md.Artist + " - " + s
+	}
+	if md.Duration > 0 {
+		s += " (" + FormatDuration(md.Duration) + ")"
+	}
+	return s
+}
+
+// FormatDuration formats a duration like "3:03" or "1:02:03".
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h, m, s := int(d/time.Hour), int(d/time.Minute)%60, int(d/time.Second)%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// Resolver resolves the metadata of media.
+type Resolver interface {
+	// Resolve returns the metadata of the medium. It returns
+	// medium.ErrNotSupported if it cannot resolve media of that provider.
+	Resolve(ctx context.Context, m medium.Medium) (Metadata, error)
+}
+
+// Multi returns a resolver that asks the resolvers in order until one
+// supports the medium.
+func Multi(resolvers ...Resolver) Resolver {
+	return multi(resolvers)
+}
+
+type multi []Resolver
+
+func (rs multi) Resolve(ctx context.Context, m medium.Medium) (Metadata, error) {
+	for _, r := range rs {
+		if md, err := r.Resolve(ctx, m); err != medium.ErrNotSupported {
+			return md, err
+		}
+	}
+	return Metadata{}, medium.ErrNotSupported
+}
+
+// Merged returns a resolver that asks all resolvers concurrently and merges
+// their metadata. Fields that earlier resolvers know take precedence. It only
+// fails if all resolvers that support the medium fail.
+func Merged(resolvers ...Resolver) Resolver {
+	return merged(resolvers)
+}
+
+type merged []Resolver
+
+func (rs merged) Resolve(ctx context.Context, m medium.Medium) (Metadata, error) {
+	mds := make([]Metadata, len(rs))
+	errs := make([]error, len(rs))
+	var wg sync.WaitGroup
+	for i, r := range rs {
+		wg.Add(1)
+		go func(i int, r Resolver) {
+			defer wg.Done()
+			mds[i], errs[i] = r.Resolve(ctx, m)
+		}(i, r)
+	}
+	wg.Wait()
+
+	var (
+		md        Metadata
+		supported bool
+		firstErr  error
+	)
+	for i, err := range errs {
+		switch {
+		case err == medium.ErrNotSupported:
+			continue
+		case err != nil:
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		supported = true
+		if md.Title == "" {
+			md.Title = mds[i].Title
+		}
+		if md.Artist == "" {
+			md.Artist = mds[i].Artist
+		}
+		if md.Duration == 0 {
+			md.Duration = mds[i].Duration
+		}
+		if md.Thumbnail == "" {
+			md.Thumbnail = mds[i].Thumbnail
+		}
+	}
+	switch {
+	case supported:
+		return md, nil
+	case firstErr != nil:
+		return Metadata{}, firstErr
+	}
+	return Metadata{}, medium.ErrNotSupported
+}
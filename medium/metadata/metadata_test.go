@@ -0,0 +1,410 @@
+package metadata_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	. "github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+)
+
+func TestMetadata_String(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		metadata Metadata
+		result   string
+	}{
+		{
+			desc:     "empty",
+			metadata: Metadata{Artist: "Sabaton", Duration: time.Minute},
+			result:   "",
+		}, {
+			desc:     "title only",
+			metadata: Metadata{Title: "Night Witches"},
+			result:   "Night Witches",
+		}, {
+			desc:     "everything",
+			metadata: Metadata{Title: "Night Witches", Artist: "Sabaton", Duration: 3*time.Minute + 3*time.Second},
+			result:   "Sabaton - Night Witches (3:03)",
+		}, {
+			desc:     "long duration",
+			metadata: Metadata{Title: "Cows", Duration: 10*time.Hour + 2*time.Second},
+			result:   "Cows (10:00:02)",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if s := tC.metadata.String(); s != tC.result {
+				t.Errorf("expected %q, got %q", tC.result, s)
+			}
+		})
+	}
+}
+
+func TestSameSong(t *testing.T) {
+	testCases := []struct {
+		desc string
+		a, b Metadata
+		same bool
+	}{
+		{
+			desc: "video and track",
+			a:    Metadata{Title: "Sabaton - Primo Victoria (Official Music Video)", Artist: "Sabaton"},
+			b:    Metadata{Title: "Primo Victoria", Artist: "Sabaton"},
+			same: true,
+		}, {
+			desc: "unknown artist",
+			a:    Metadata{Title: "SABATON – Night Witches [HD]", Artist: "Nuclear Blast Records"},
+			b:    Metadata{Title: "Night Witches"},
+			same: true,
+		}, {
+			desc: "channel suffix",
+			a:    Metadata{Title: "Night Witches", Artist: "Sabaton - Topic"},
+			b:    Metadata{Title: "Night Witches", Artist: "SabatonVEVO"},
+			same: true,
+		}, {
+			desc: "featured artists",
+			a:    Metadata{Title: "Wodka feat. Someone", Artist: "Da Tweekaz"},
+			b:    Metadata{Title: "Da Tweekaz - Wodka (ft. Someone Else) [Lyrics]"},
+			same: true,
+		}, {
+			desc: "punctuation and ampersand",
+			a:    Metadata{Title: "Rock & Roll, Baby!", Artist: "Cows"},
+			b:    Metadata{Title: "rock and roll baby", Artist: "COWS"},
+			same: true,
+		}, {
+			desc: "remix",
+			a:    Metadata{Title: "Wodka (Remix)", Artist: "Da Tweekaz"},
+			b:    Metadata{Title: "Wodka", Artist: "Da Tweekaz"},
+			same: false,
+		}, {
+			desc: "other artist",
+			a:    Metadata{Title: "Night Witches", Artist: "Sabaton"},
+			b:    Metadata{Title: "Night Witches", Artist: "Cows"},
+			same: false,
+		}, {
+			desc: "unknown titles",
+			a:    Metadata{Artist: "Sabaton"},
+			b:    Metadata{Artist: "Sabaton"},
+			same: false,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if same := SameSong(tC.a, tC.b); same != tC.same {
+				t.Errorf("expected %v, got %v", tC.same, same)
+			}
+			if same := SameSong(tC.b, tC.a); same != tC.same {
+				t.Errorf("expected %v in reverse, got %v", tC.same, same)
+			}
+		})
+	}
+}
+
+func TestOEmbed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("url") {
+		case "https://www.youtube.com/watch?v=cNtZAbq2Ig4":
+			fmt.Fprint(w, `{"title": "Night Witches", "author_name": "Sabaton", "thumbnail_url": "https://i.ytimg.com/vi/cNtZAbq2Ig4/hqdefault.jpg"}`)
+		case "https://soundcloud.com/fu-ggbeats/sludge":
+			fmt.Fprint(w, `{"title": "Sludge", "author_name": "FU-GG", "duration": 183.5}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	resolver := &OEmbed{BaseURL: server.URL}
+
+	testCases := []struct {
+		desc     string
+		rawurl   string
+		err      bool
+		metadata Metadata
+	}{
+		{
+			desc:   "youtube",
+			rawurl: "https://www.youtube.com/watch?v=cNtZAbq2Ig4&t=30",
+			metadata: Metadata{
+				Title:     "Night Witches",
+				Artist:    "Sabaton",
+				Thumbnail: "https://i.ytimg.com/vi/cNtZAbq2Ig4/hqdefault.jpg",
+			},
+		}, {
+			desc:   "with duration",
+			rawurl: "https://soundcloud.com/fu-ggbeats/sludge",
+			metadata: Metadata{
+				Title:    "Sludge",
+				Artist:   "FU-GG",
+				Duration: 183500 * time.Millisecond,
+			},
+		}, {
+			desc:   "not found",
+			rawurl: "https://youtu.be/YgGzAKP_HuM",
+			err:    true,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			m, err := medium.New(tC.rawurl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			md, err := resolver.Resolve(context.Background(), m)
+			if (err != nil) != tC.err {
+				t.Fatalf("expected error: %t, got %q", tC.err, err)
+			}
+			if md != tC.metadata {
+				t.Errorf("expected %#v, got %#v", tC.metadata, md)
+			}
+		})
+	}
+
+	t.Run("unknown provider", func(t *testing.T) {
+		if _, err := resolver.Resolve(context.Background(), someMedium{}); err != medium.ErrNotSupported {
+			t.Errorf("expected error %q, got %q", medium.ErrNotSupported, err)
+		}
+	})
+}
+
+func TestYouTube(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/videos" || q.Get("key") != "secret" || q.Get("part") != "snippet,contentDetails" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch q.Get("id") {
+		case "cNtZAbq2Ig4":
+			fmt.Fprint(w, `{"items": [{
+				"snippet": {"title": "Night Witches", "channelTitle": "Sabaton", "thumbnails": {"high": {"url": "https://i.ytimg.com/vi/cNtZAbq2Ig4/hqdefault.jpg"}}},
+				"contentDetails": {"duration": "PT3M3S"}
+			}]}`)
+		case "YgGzAKP_HuM":
+			fmt.Fprint(w, `{"items": [{"snippet": {"title": "Cows Cows Cows (10 Hour Loop)"}, "contentDetails": {"duration": "PT10H0M1S"}}]}`)
+		default:
+			fmt.Fprint(w, `{"items": []}`)
+		}
+	}))
+	defer server.Close()
+	resolver := &YouTube{APIKey: "secret", BaseURL: server.URL}
+
+	testCases := []struct {
+		desc     string
+		rawurl   string
+		err      bool
+		metadata Metadata
+	}{
+		{
+			desc:   "video",
+			rawurl: "https://www.youtube.com/watch?v=cNtZAbq2Ig4",
+			metadata: Metadata{
+				Title:     "Night Witches",
+				Artist:    "Sabaton",
+				Duration:  3*time.Minute + 3*time.Second,
+				Thumbnail: "https://i.ytimg.com/vi/cNtZAbq2Ig4/hqdefault.jpg",
+			},
+		}, {
+			desc:     "clip of a loop",
+			rawurl:   "https://youtu.be/YgGzAKP_HuM?t=95",
+			metadata: Metadata{Title: "Cows Cows Cows (10 Hour Loop)", Duration: 10*time.Hour + time.Second},
+		}, {
+			desc:   "not found",
+			rawurl: "https://youtu.be/aaaaaaaaaaa",
+			err:    true,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			m, err := medium.New(tC.rawurl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			md, err := resolver.Resolve(context.Background(), m)
+			if (err != nil) != tC.err {
+				t.Fatalf("expected error: %t, got %q", tC.err, err)
+			}
+			if md != tC.metadata {
+				t.Errorf("expected %#v, got %#v", tC.metadata, md)
+			}
+		})
+	}
+
+	t.Run("other provider", func(t *testing.T) {
+		m, _ := medium.New("https://soundcloud.com/fu-ggbeats/sludge")
+		if _, err := resolver.Resolve(context.Background(), m); err != medium.ErrNotSupported {
+			t.Errorf("expected error %q, got %q", medium.ErrNotSupported, err)
+		}
+	})
+}
+
+func TestSoundCloud(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fu-ggbeats/sludge":
+			fmt.Fprint(w, `<html><noscript><article><meta itemprop="duration" content="PT00H03M25S" /></article></noscript></html>`)
+		case "/fu-ggbeats/unknown":
+			fmt.Fprint(w, `<html></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	resolver := &SoundCloud{BaseURL: server.URL}
+
+	testCases := []struct {
+		desc     string
+		rawurl   string
+		err      bool
+		duration time.Duration
+	}{
+		{desc: "track", rawurl: "https://soundcloud.com/fu-ggbeats/sludge", duration: 3*time.Minute + 25*time.Second},
+		{desc: "without duration", rawurl: "https://soundcloud.com/fu-ggbeats/unknown"},
+		{desc: "not found", rawurl: "https://soundcloud.com/fu-ggbeats/gone", err: true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			m, err := medium.New(tC.rawurl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			md, err := resolver.Resolve(context.Background(), m)
+			if (err != nil) != tC.err {
+				t.Fatalf("expected error: %t, got %q", tC.err, err)
+			}
+			if md.Duration != tC.duration {
+				t.Errorf("expected duration %s, got %s", tC.duration, md.Duration)
+			}
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	now := time.Date(2019, 11, 1, 20, 0, 0, 0, time.UTC)
+	resolver := &countingResolver{}
+	cache := NewCacheWithClock(resolver, time.Hour, func() time.Time { return now })
+
+	resolve := func(m medium.Medium) Metadata {
+		md, err := cache.Resolve(context.Background(), m)
+		if err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+		return md
+	}
+
+	if md := resolve(someMedium{"a"}); md.Title != "a #1" {
+		t.Errorf("expected first resolution, got %q", md.Title)
+	}
+	now = now.Add(59 * time.Minute)
+	if md := resolve(someMedium{"a"}); md.Title != "a #1" {
+		t.Errorf("expected cached resolution, got %q", md.Title)
+	}
+	if md := resolve(someMedium{"b"}); md.Title != "b #2" {
+		t.Errorf("expected other media to be resolved, got %q", md.Title)
+	}
+	now = now.Add(time.Minute)
+	if md := resolve(someMedium{"a"}); md.Title != "a #3" {
+		t.Errorf("expected expired entry to be resolved again, got %q", md.Title)
+	}
+
+	resolver.err = errors.New("offline")
+	if _, err := cache.Resolve(context.Background(), someMedium{"c"}); err != resolver.err {
+		t.Errorf("expected error %q, got %q", resolver.err, err)
+	}
+	resolver.err = nil
+	if md := resolve(someMedium{"c"}); md.Title != "c #5" {
+		t.Errorf("expected errors not to be cached, got %q", md.Title)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	failing := &countingResolver{err: errors.New("offline")}
+	resolver := Multi(unsupportedResolver{}, &countingResolver{}, failing)
+
+	md, err := resolver.Resolve(context.Background(), someMedium{"a"})
+	if err != nil || md.Title != "a #1" {
+		t.Errorf("expected first supporting resolver to resolve, got %#v (%v)", md, err)
+	}
+	if failing.calls != 0 {
+		t.Errorf("expected later resolvers not to be asked, got %d calls", failing.calls)
+	}
+
+	resolver = Multi(unsupportedResolver{}, failing, &countingResolver{})
+	if _, err := resolver.Resolve(context.Background(), someMedium{"a"}); err != failing.err {
+		t.Errorf("expected error %q, got %q", failing.err, err)
+	}
+
+	resolver = Multi(unsupportedResolver{})
+	if _, err := resolver.Resolve(context.Background(), someMedium{"a"}); err != medium.ErrNotSupported {
+		t.Errorf("expected error %q, got %q", medium.ErrNotSupported, err)
+	}
+}
+
+func TestMerged(t *testing.T) {
+	title := staticResolver{Title: "Sludge", Artist: "FU-GG"}
+	duration := staticResolver{Title: "sludge", Duration: time.Minute}
+	failing := &countingResolver{err: errors.New("offline")}
+
+	resolver := Merged(unsupportedResolver{}, title, failing, duration)
+	md, err := resolver.Resolve(context.Background(), someMedium{"a"})
+	if want := (Metadata{Title: "Sludge", Artist: "FU-GG", Duration: time.Minute}); err != nil || md != want {
+		t.Errorf("expected %#v, got %#v (%v)", want, md, err)
+	}
+
+	resolver = Merged(unsupportedResolver{}, failing)
+	if _, err := resolver.Resolve(context.Background(), someMedium{"a"}); err != failing.err {
+		t.Errorf("expected error %q, got %q", failing.err, err)
+	}
+
+	resolver = Merged(unsupportedResolver{})
+	if _, err := resolver.Resolve(context.Background(), someMedium{"a"}); err != medium.ErrNotSupported {
+		t.Errorf("expected error %q, got %q", medium.ErrNotSupported, err)
+	}
+}
+
+type staticResolver Metadata
+
+func (r staticResolver) Resolve(context.Context, medium.Medium) (Metadata, error) {
+	return Metadata(r), nil
+}
+
+type unsupportedResolver struct{}
+
+func (unsupportedResolver) Resolve(context.Context, medium.Medium) (Metadata, error) {
+	return Metadata{}, medium.ErrNotSupported
+}
+
+type countingResolver struct {
+	calls int
+	err   error
+}
+
+func (r *countingResolver) Resolve(_ context.Context, m medium.Medium) (Metadata, error) {
+	r.calls++
+	if r.err != nil {
+		return Metadata{}, r.err
+	}
+	return Metadata{Title: fmt.Sprintf("%s #%d", m.ID(), r.calls)}, nil
+}
+
+type someProvider struct{}
+
+func (p someProvider) String() string {
+	return "foobar"
+}
+
+type someMedium struct {
+	string
+}
+
+func (m someMedium) Provider() medium.Provider {
+	return someProvider{}
+}
+
+func (m someMedium) ID() interface{} {
+	return m.string
+}
@@ -0,0 +1,80 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+)
+
+// oEmbedEndpoints are the oEmbed endpoints of the providers.
+var oEmbedEndpoints = map[medium.Provider]string{
+	medium.ProviderYouTube:    "https://www.youtube.com/oembed",
+	medium.ProviderSoundCloud: "https://soundcloud.com/oembed",
+	medium.ProviderSpotify:    "https://open.spotify.com/oembed",
+	medium.ProviderMixcloud:   "https://www.mixcloud.com/oembed/",
+}
+
+// OEmbed resolves metadata using the oEmbed endpoints of the providers.
+type OEmbed struct {
+	// BaseURL is the oEmbed endpoint that is used for all providers instead of
+	// their own, e.g. an oEmbed proxy.
+	BaseURL string
+	// Client is used for the requests. It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Resolve returns the title, author and thumbnail of the medium and its
+// duration if the endpoint provides it.
+func (o *OEmbed) Resolve(ctx context.Context, m medium.Medium) (Metadata, error) {
+	endpoint, ok := oEmbedEndpoints[m.Provider()]
+	// metadata is about the whole medium, so the range of clips is dropped
+	mediumURL := medium.URL(medium.NewClip(m, 0, 0))
+	if !ok || mediumURL == "" || medium.IsCollection(m) {
+		return Metadata{}, medium.ErrNotSupported
+	}
+	client := o.Client
+	if o.BaseURL != "" {
+		endpoint = o.BaseURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	params := url.Values{
+		"url":    {mediumURL},
+		"format": {"json"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Metadata{}, fmt.Errorf("oembed endpoint responded with %s", resp.Status)
+	}
+
+	var body struct {
+		Title        string  `json:"title"`
+		AuthorName   string  `json:"author_name"`
+		ThumbnailURL string  `json:"thumbnail_url"`
+		Duration     float64 `json:"duration"` // not part of the spec, but common
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Metadata{}, err
+	}
+	return Metadata{
+		Title:     body.Title,
+		Artist:    body.AuthorName,
+		Duration:  time.Duration(body.Duration * float64(time.Second)),
+		Thumbnail: body.ThumbnailURL,
+	}, nil
+}
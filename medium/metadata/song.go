@@ -0,0 +1,57 @@
+package metadata
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// bracketed parts of titles that do not belong to the song, like
+	// "(Official Video)", "[HD]" or "(feat. Someone)"
+	titleNoise = regexp.MustCompile(`(?i)[(\[][^)\]]*\b(official|video|audio|lyrics?|hd|hq|4k|visuali[sz]er|remaster(ed)?|explicit|clip|feat|ft|featuring)\b[^)\]]*[)\]]`)
+	// featured artists that are not in brackets
+	featuring = regexp.MustCompile(`(?i)\s(feat\.?|ft\.?|featuring)\s[^(\[]*`)
+	// suffixes of channel names that are not part of the artist
+	channelNoise = regexp.MustCompile(`(?i)(\s-\s*topic|vevo|\s+official)$`)
+)
+
+// Song returns the normalised artist and title of the medium, which are the
+// same for the same song from different providers. Noise like "(Official
+// Video)", "[HD]" and featured artists is removed. If the title has the form
+// "artist - title", the artist is taken from the title, because the artist of
+// videos is often the uploading channel.
+func (md Metadata) Song() (artist, title string) {
+	artist, title = md.Artist, md.Title
+	for _, sep := range []string{" - ", " – ", " — "} {
+		if i := strings.Index(title, sep); i >= 0 {
+			artist, title = title[:i], title[i+len(sep):]
+			break
+		}
+	}
+	artist = channelNoise.ReplaceAllString(strings.TrimSpace(artist), "")
+	return normalize(artist), normalize(title)
+}
+
+// SameSong returns whether both metadata probably describe the same song. The
+// titles must be known and equal. The artists must be equal if both are
+// known.
+func SameSong(a, b Metadata) bool {
+	aArtist, aTitle := a.Song()
+	bArtist, bTitle := b.Song()
+	if aTitle == "" || aTitle != bTitle {
+		return false
+	}
+	return aArtist == "" || bArtist == "" || aArtist == bArtist
+}
+
+// normalize removes noise, punctuation and case from the text.
+func normalize(s string) string {
+	s = titleNoise.ReplaceAllString(s, " ")
+	s = featuring.ReplaceAllString(s, " ")
+	s = strings.ReplaceAll(strings.ToLower(s), "&", " and ")
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,66 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+)
+
+// soundCloudPageSize is how much of a track page is read at most.
+const soundCloudPageSize = 4 << 20
+
+var soundCloudDuration = regexp.MustCompile(`itemprop="duration"\s+content="([^"]+)"`)
+
+// SoundCloud resolves the duration of SoundCloud tracks from their pages,
+// because their oEmbed endpoint does not provide it. It is meant to be merged
+// with OEmbed, which provides the rest.
+type SoundCloud struct {
+	// BaseURL replaces "https://soundcloud.com" in the urls of tracks.
+	BaseURL string
+	// Client is used for the requests. It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Resolve returns the duration of the track.
+func (s *SoundCloud) Resolve(ctx context.Context, m medium.Medium) (Metadata, error) {
+	trackURL := medium.URL(m)
+	if m.Provider() != medium.ProviderSoundCloud || medium.IsCollection(m) || trackURL == "" {
+		return Metadata{}, medium.ErrNotSupported
+	}
+	client := s.Client
+	if s.BaseURL != "" {
+		trackURL = s.BaseURL + strings.TrimPrefix(trackURL, "https://soundcloud.com")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, trackURL, nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Metadata{}, fmt.Errorf("soundcloud responded with %s", resp.Status)
+	}
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, soundCloudPageSize))
+	if err != nil {
+		return Metadata{}, err
+	}
+	match := soundCloudDuration.FindSubmatch(page)
+	if match == nil {
+		return Metadata{}, nil
+	}
+	duration, _ := parseISODuration(string(match[1]))
+	return Metadata{Duration: duration}, nil
+}
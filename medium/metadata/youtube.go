@@ -0,0 +1,106 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+)
+
+// YouTube resolves the metadata of YouTube videos using the YouTube Data API.
+// Unlike oEmbed, it provides their duration.
+type YouTube struct {
+	// APIKey is the key used to access the api.
+	APIKey string
+	// BaseURL is the base url of the api. It defaults to
+	// medium.YouTubeAPIBaseURL.
+	BaseURL string
+	// Client is used for the requests. It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Resolve returns the title, channel, duration and thumbnail of the video.
+func (y *YouTube) Resolve(ctx context.Context, m medium.Medium) (Metadata, error) {
+	m = medium.NewClip(m, 0, 0) // metadata is about the whole video
+	if m.Provider() != medium.ProviderYouTube || medium.IsCollection(m) {
+		return Metadata{}, medium.ErrNotSupported
+	}
+	baseURL, client := y.BaseURL, y.Client
+	if baseURL == "" {
+		baseURL = medium.YouTubeAPIBaseURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	params := url.Values{
+		"part": {"snippet,contentDetails"},
+		"id":   {fmt.Sprint(m.ID())},
+		"key":  {y.APIKey},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/videos?"+params.Encode(), nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Metadata{}, fmt.Errorf("youtube api responded with %s", resp.Status)
+	}
+
+	var body struct {
+		Items []struct {
+			Snippet struct {
+				Title        string `json:"title"`
+				ChannelTitle string `json:"channelTitle"`
+				Thumbnails   map[string]struct {
+					URL string `json:"url"`
+				} `json:"thumbnails"`
+			} `json:"snippet"`
+			ContentDetails struct {
+				Duration string `json:"duration"`
+			} `json:"contentDetails"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Metadata{}, err
+	}
+	if len(body.Items) == 0 {
+		return Metadata{}, errors.New("youtube video not found")
+	}
+	video := body.Items[0]
+	duration, _ := parseISODuration(video.ContentDetails.Duration)
+	return Metadata{
+		Title:     video.Snippet.Title,
+		Artist:    video.Snippet.ChannelTitle,
+		Duration:  duration,
+		Thumbnail: video.Snippet.Thumbnails["high"].URL,
+	}, nil
+}
+
+var isoDuration = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseISODuration parses ISO 8601 durations like "PT1H2M3S" or "P1DT2H".
+func parseISODuration(s string) (time.Duration, bool) {
+	match := isoDuration.FindStringSubmatch(s)
+	if match == nil {
+		return 0, false
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if n, err := strconv.Atoi(match[i+1]); err == nil {
+			d += time.Duration(n) * unit
+		}
+	}
+	return d, true
+}
@@ -0,0 +1,73 @@
+package medium
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ProviderMixcloud is the provider for Mixcloud shows.
+var ProviderMixcloud = simpleProvider("mixcloud")
+
+func init() {
+	Register(Registration{
+		Provider: ProviderMixcloud,
+		Hosts:    []string{"mixcloud.com", "www.mixcloud.com", "m.mixcloud.com"},
+		Parse:    NewMixcloudShowFromURL,
+		ParseID:  NewMixcloudShow,
+	})
+}
+
+// mixcloudShow is the id of a show in the form "user/show" in lower case.
+type mixcloudShow string
+
+func (m mixcloudShow) Provider() Provider {
+	return ProviderMixcloud
+}
+
+func (m mixcloudShow) ID() interface{} {
+	return string(m)
+}
+
+func (m mixcloudShow) URL() string {
+	return (&url.URL{Scheme: "https", Host: "www.mixcloud.com", Path: "/" + string(m) + "/"}).String()
+}
+
+var (
+	mixcloudName = regexp.MustCompile(`^[\p{Ll}\p{Lo}\p{Nd}_-]+$`)
+
+	// first path parts that are pages of Mixcloud and not users
+	mixcloudReserved = map[string]bool{
+		"about": true, "categories": true, "dashboard": true, "discover": true,
+		"live": true, "pro": true, "search": true, "select": true,
+		"settings": true, "tag": true, "upload": true,
+	}
+	// second path parts that are tabs of a profile and not shows
+	mixcloudProfileTabs = map[string]bool{
+		"activity": true, "favorites": true, "followers": true,
+		"following": true, "hosts": true, "listens": true, "playlists": true,
+		"reposts": true, "stream": true, "uploads": true,
+	}
+)
+
+// NewMixcloudShow returns a new medium that is a Mixcloud show from its id in
+// the form "user/show".
+func NewMixcloudShow(id string) (Medium, error) {
+	parts := strings.Split(strings.ToLower(strings.Trim(id, "/")), "/")
+	if len(parts) != 2 || mixcloudReserved[parts[0]] || mixcloudProfileTabs[parts[1]] {
+		return nil, ErrInvalidURL
+	}
+	for _, p := range parts {
+		if !mixcloudName.MatchString(p) {
+			return nil, ErrInvalidURL
+		}
+	}
+	return mixcloudShow(strings.Join(parts, "/")), nil
+}
+
+// NewMixcloudShowFromURL returns a new medium that is a Mixcloud show from an
+// url like https://www.mixcloud.com/user/show/. User pages are rejected with
+// ErrInvalidURL.
+func NewMixcloudShowFromURL(url *url.URL) (Medium, error) {
+	return NewMixcloudShow(url.Path)
+}
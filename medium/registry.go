@@ -0,0 +1,131 @@
+package medium
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// URLParser creates a medium from a url. It returns ErrInvalidURL if the url
+// belongs to the provider but does not point to a medium.
+type URLParser func(url *url.URL) (Medium, error)
+
+// IDParser creates a medium from its id as returned by Medium.ID. It is used
+// to parse uris.
+type IDParser func(id string) (Medium, error)
+
+// Registration describes a provider and how to create its media from urls.
+type Registration struct {
+	// Provider is the provider that is registered.
+	Provider Provider
+	// Hosts are the host patterns the provider handles. A pattern is either
+	// a host name like "youtube.com" or a wildcard like "*.bandcamp.com",
+	// which matches all sub domains. The pattern "*" matches all hosts, but
+	// only if no other provider handles the host.
+	Hosts []string
+	// Schemes are the uri schemes the provider handles, like "spotify" for
+	// "spotify:track:id".
+	Schemes []string
+	// Parse creates a medium from a url matching one of the hosts or schemes.
+	Parse URLParser
+	// ParseCollection optionally creates the collection a url refers to. It
+	// is used by NewCollection.
+	ParseCollection URLParser
+	// ParseID creates a medium from its id. It is used by ParseURI.
+	ParseID IDParser
+}
+
+var registry = struct {
+	sync.RWMutex
+	registrations []Registration
+}{}
+
+// Register makes a provider available to New. It panics if the provider is
+// registered twice or if the registration is incomplete. Providers without
+// hosts and schemes do not need a url parser.
+func Register(r Registration) {
+	if r.Provider == nil || (r.Parse == nil && len(r.Hosts)+len(r.Schemes) > 0) {
+		panic("medium: Register with incomplete registration")
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	for _, existing := range registry.registrations {
+		if existing.Provider.String() == r.Provider.String() {
+			panic("medium: Register called twice for provider " + r.Provider.String())
+		}
+	}
+	registry.registrations = append(registry.registrations, r)
+}
+
+// unregister removes the provider with the name. It is used by tests.
+func unregister(name string) {
+	registry.Lock()
+	defer registry.Unlock()
+	for i, r := range registry.registrations {
+		if r.Provider.String() == name {
+			registry.registrations = append(registry.registrations[:i:i], registry.registrations[i+1:]...)
+			return
+		}
+	}
+}
+
+// Providers returns all registered providers in the order they were
+// registered.
+func Providers() []Provider {
+	registry.RLock()
+	defer registry.RUnlock()
+	providers := make([]Provider, len(registry.registrations))
+	for i, r := range registry.registrations {
+		providers[i] = r.Provider
+	}
+	return providers
+}
+
+// lookup returns the first registration that handles the url.
+func lookup(url *url.URL) (Registration, bool) {
+	host, scheme := strings.ToLower(url.Hostname()), strings.ToLower(url.Scheme)
+	registry.RLock()
+	defer registry.RUnlock()
+	var fallback *Registration
+	for i, r := range registry.registrations {
+		if host == "" {
+			for _, s := range r.Schemes {
+				if strings.ToLower(s) == scheme {
+					return r, true
+				}
+			}
+			continue
+		}
+		for _, pattern := range r.Hosts {
+			if pattern == "*" && fallback == nil {
+				fallback = &registry.registrations[i]
+			} else if matchHost(pattern, host) {
+				return r, true
+			}
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return Registration{}, false
+}
+
+// lookupProvider returns the registration of the provider with the name.
+func lookupProvider(name string) (Registration, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	for _, r := range registry.registrations {
+		if r.Provider.String() == name {
+			return r, true
+		}
+	}
+	return Registration{}, false
+}
+
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
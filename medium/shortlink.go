@@ -0,0 +1,143 @@
+package medium
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTooManyRedirects is returned if a short link redirects more often than
+// allowed.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// DefaultRedirectors are the hosts of common link shorteners and redirectors.
+var DefaultRedirectors = []string{
+	"bit.ly", "buff.ly", "goo.gl", "is.gd", "lnkd.in", "ow.ly", "rb.gy",
+	"t.co", "t.ly", "tinyurl.com", "spoti.fi", "spotify.link", "snd.sc",
+	"on.soundcloud.com", "fb.me", "l.facebook.com", "l.instagram.com",
+	"vm.tiktok.com",
+}
+
+// Unwrapper unwraps short links by following their redirects before they are
+// passed to New.
+type Unwrapper struct {
+	// Hosts are the host patterns of the redirectors, as in Registration.
+	// Only redirects of these hosts are followed.
+	Hosts []string
+	// MaxHops is the maximum number of redirects that are followed.
+	MaxHops int
+	// Timeout limits the time it may take to unwrap a link.
+	Timeout time.Duration
+	// Client is used for the requests. It must not follow redirects itself.
+	// It defaults to a client that only connects to public addresses, like
+	// PublicClient.
+	Client *http.Client
+
+	l     sync.Mutex
+	cache map[string]string
+}
+
+// maxCachedLinks limits the number of cached targets. The cache is emptied if
+// it is full.
+const maxCachedLinks = 1000
+
+// NewUnwrapper returns a new unwrapper for the hosts that follows at most 5
+// redirects within 10 seconds.
+func NewUnwrapper(hosts ...string) *Unwrapper {
+	return &Unwrapper{
+		Hosts:   hosts,
+		MaxHops: 5,
+		Timeout: 10 * time.Second,
+		Client:  newUnwrapClient(),
+	}
+}
+
+// Unwrap returns the url the link redirects to. Links to hosts that are not
+// redirectors are returned as they are, as are links that do not redirect.
+func (u *Unwrapper) Unwrap(ctx context.Context, rawurl string) (string, error) {
+	link, err := url.Parse(rawurl)
+	if err != nil || !u.isRedirector(link) {
+		return rawurl, nil
+	}
+	u.l.Lock()
+	target, ok := u.cache[rawurl]
+	u.l.Unlock()
+	if ok {
+		return target, nil
+	}
+
+	if u.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.Timeout)
+		defer cancel()
+	}
+	for hops := 0; u.isRedirector(link); hops++ {
+		next, err := u.follow(ctx, link)
+		if err != nil {
+			return "", err
+		}
+		if next == nil {
+			break // does not redirect
+		}
+		if hops >= u.MaxHops {
+			return "", ErrTooManyRedirects
+		}
+		link = next
+	}
+
+	u.l.Lock()
+	defer u.l.Unlock()
+	if u.cache == nil || len(u.cache) >= maxCachedLinks {
+		u.cache = make(map[string]string)
+	}
+	u.cache[rawurl] = link.String()
+	return link.String(), nil
+}
+
+// follow returns the location the link redirects to or nil if it does not.
+func (u *Unwrapper) follow(ctx context.Context, link *url.URL) (*url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := u.Client
+	if client == nil {
+		client = newUnwrapClient()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 || location == "" {
+		return nil, nil
+	}
+	return link.Parse(location)
+}
+
+// newUnwrapClient returns a client that only connects to public addresses and
+// does not follow redirects.
+func newUnwrapClient() *http.Client {
+	return &http.Client{
+		Transport: PublicClient.Transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// isRedirector returns whether the link points to a redirector.
+func (u *Unwrapper) isRedirector(link *url.URL) bool {
+	host := strings.ToLower(link.Hostname())
+	for _, pattern := range u.Hosts {
+		if matchHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package medium
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ProviderSoundCloud is the provider for SoundCloud tracks and sets.
+var ProviderSoundCloud = simpleProvider("soundcloud")
+
+func init() {
+	Register(Registration{
+		Provider: ProviderSoundCloud,
+		Hosts:    []string{"soundcloud.com", "www.soundcloud.com", "m.soundcloud.com", "on.soundcloud.com"},
+		Parse:    NewSoundCloudMediumFromURL,
+		ParseID:  NewSoundCloudMedium,
+	})
+}
+
+// soundCloudMedium is the permalink path of a track ("user/track") or a set
+// ("user/sets/set") in lower case. Short links are kept as "on/code" as they
+// cannot be resolved without asking SoundCloud.
+type soundCloudMedium string
+
+func (m soundCloudMedium) Provider() Provider {
+	return ProviderSoundCloud
+}
+
+func (m soundCloudMedium) ID() interface{} {
+	return string(m)
+}
+
+func (m soundCloudMedium) URL() string {
+	if code := strings.TrimPrefix(string(m), "on/"); code != string(m) {
+		return "https://on.soundcloud.com/" + code
+	}
+	return "https://soundcloud.com/" + string(m)
+}
+
+var (
+	soundCloudPermalink = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	soundCloudShortCode = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	soundCloudSecret    = regexp.MustCompile(`^s-[A-Za-z0-9]+$`)
+
+	// first path parts that are pages of SoundCloud and not users
+	soundCloudReserved = map[string]bool{
+		"charts": true, "discover": true, "imprint": true, "jobs": true,
+		"messages": true, "mobile": true, "notifications": true, "pages": true,
+		"people": true, "search": true, "settings": true, "stream": true,
+		"tags": true, "terms-of-use": true, "upload": true, "you": true,
+	}
+	// second path parts that are tabs of a profile and not tracks
+	soundCloudProfileTabs = map[string]bool{
+		"albums": true, "comments": true, "followers": true, "following": true,
+		"likes": true, "playlists": true, "popular-tracks": true,
+		"reposts": true, "sets": true, "spotlight": true, "tracks": true,
+	}
+)
+
+// NewSoundCloudMedium returns a new medium that is a SoundCloud track or set
+// from its id, e.g. "user/track", "user/track/s-secret", "user/sets/set" or
+// "on/code" for short links.
+func NewSoundCloudMedium(id string) (Medium, error) {
+	parts := strings.Split(strings.Trim(id, "/"), "/")
+	if len(parts) == 2 && parts[0] == "on" {
+		if !soundCloudShortCode.MatchString(parts[1]) {
+			return nil, ErrInvalidURL
+		}
+		return soundCloudMedium(id), nil
+	}
+	// permalinks are case insensitive, secret tokens are not
+	var secret string
+	if len(parts) == 3 && soundCloudSecret.MatchString(parts[2]) {
+		secret, parts = parts[2], parts[:2]
+	}
+	for i, p := range parts {
+		parts[i] = strings.ToLower(p)
+		if !soundCloudPermalink.MatchString(parts[i]) {
+			return nil, ErrInvalidURL
+		}
+	}
+	switch {
+	case soundCloudReserved[parts[0]]:
+		return nil, ErrInvalidURL
+	case len(parts) == 2 && !soundCloudProfileTabs[parts[1]]:
+	case len(parts) == 3 && parts[1] == "sets":
+	default:
+		return nil, ErrInvalidURL
+	}
+	if secret != "" {
+		parts = append(parts, secret)
+	}
+	return soundCloudMedium(strings.Join(parts, "/")), nil
+}
+
+// NewSoundCloudMediumFromURL returns a new medium that is a SoundCloud track
+// or set from a url. User and profile pages are rejected with ErrInvalidURL.
+func NewSoundCloudMediumFromURL(url *url.URL) (Medium, error) {
+	parts := strings.Split(strings.Trim(url.Path, "/"), "/")
+
+	// short links
+	if strings.EqualFold(url.Hostname(), "on.soundcloud.com") {
+		if len(parts) != 1 {
+			return nil, ErrInvalidURL
+		}
+		return NewSoundCloudMedium("on/" + parts[0])
+	}
+
+	switch {
+	case len(parts) >= 3 && strings.EqualFold(parts[1], "sets"):
+		parts = parts[:3]
+	case len(parts) >= 3 && soundCloudSecret.MatchString(parts[2]):
+		// private tracks can only be played with their secret token
+		parts = parts[:3]
+	case len(parts) >= 2:
+		// drop sub pages like comments
+		parts = parts[:2]
+	}
+	return NewSoundCloudMedium(strings.Join(parts, "/"))
+}
@@ -0,0 +1,64 @@
+package medium
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ProviderSpotify is the provider for Spotify tracks.
+var ProviderSpotify = simpleProvider("spotify")
+
+func init() {
+	Register(Registration{
+		Provider: ProviderSpotify,
+		Hosts:    []string{"open.spotify.com", "play.spotify.com"},
+		Schemes:  []string{"spotify"},
+		Parse:    NewSpotifyTrackFromURL,
+		ParseID:  NewSpotifyTrack,
+	})
+}
+
+type spotifyTrack string
+
+func (m spotifyTrack) Provider() Provider {
+	return ProviderSpotify
+}
+
+func (m spotifyTrack) ID() interface{} {
+	return string(m)
+}
+
+func (m spotifyTrack) URL() string {
+	return "https://open.spotify.com/track/" + string(m)
+}
+
+var spotifyID = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+
+// NewSpotifyTrack returns a new medium that is a Spotify track.
+func NewSpotifyTrack(trackID string) (Medium, error) {
+	if !spotifyID.MatchString(trackID) {
+		return nil, ErrInvalidURL
+	}
+	return spotifyTrack(trackID), nil
+}
+
+// NewSpotifyTrackFromURL returns a new medium that is a Spotify track from a
+// url like https://open.spotify.com/intl-de/track/id?si=foo or an uri like
+// spotify:track:id.
+func NewSpotifyTrackFromURL(url *url.URL) (Medium, error) {
+	var parts []string
+	if url.Opaque != "" {
+		parts = strings.Split(url.Opaque, ":")
+	} else {
+		parts = strings.Split(strings.Trim(url.Path, "/"), "/")
+		// skip localisation and embed prefixes
+		for len(parts) > 0 && (strings.HasPrefix(parts[0], "intl-") || parts[0] == "embed") {
+			parts = parts[1:]
+		}
+	}
+	if len(parts) != 2 || parts[0] != "track" {
+		return nil, ErrInvalidURL
+	}
+	return NewSpotifyTrack(parts[1])
+}
@@ -0,0 +1,35 @@
+package medium
+
+import "regexp"
+
+// ProviderTelegram is the provider for audio files and voice notes that were
+// uploaded to Telegram.
+var ProviderTelegram = simpleProvider("telegram")
+
+func init() {
+	Register(Registration{
+		Provider: ProviderTelegram,
+		ParseID:  NewTelegramFile,
+	})
+}
+
+// telegramFile is the file id of the upload.
+type telegramFile string
+
+func (m telegramFile) Provider() Provider {
+	return ProviderTelegram
+}
+
+func (m telegramFile) ID() interface{} {
+	return string(m)
+}
+
+var telegramFileID = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// NewTelegramFile returns a new medium that is a file uploaded to Telegram.
+func NewTelegramFile(fileID string) (Medium, error) {
+	if !telegramFileID.MatchString(fileID) {
+		return nil, ErrInvalidURL
+	}
+	return telegramFile(fileID), nil
+}
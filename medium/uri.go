@@ -0,0 +1,87 @@
+package medium
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// URL returns the canonical url to share the medium or an empty string if the
+// medium has none. Media provide their url with a method URL() string.
+func URL(m Medium) string {
+	if u, ok := m.(interface{ URL() string }); ok {
+		return u.URL()
+	}
+	return ""
+}
+
+// URI returns the uri of the medium in the form "provider:id", e.g.
+// "youtube:cNtZAbq2Ig4". Time ranges of clips are not part of the uri.
+func URI(m Medium) string {
+	return fmt.Sprintf("%s:%v", m.Provider(), m.ID())
+}
+
+// ParseURI returns the medium of an uri as returned by URI. It returns
+// ErrNotSupported if the provider is unknown or cannot parse ids.
+func ParseURI(uri string) (Medium, error) {
+	i := strings.Index(uri, ":")
+	if i < 0 {
+		return nil, ErrNotSupported
+	}
+	r, ok := lookupProvider(uri[:i])
+	if !ok || r.ParseID == nil {
+		return nil, ErrNotSupported
+	}
+	return r.ParseID(uri[i+1:])
+}
+
+// JSON wraps a medium to marshal it to and unmarshal it from JSON, e.g.
+// {"uri": "youtube:cNtZAbq2Ig4", "start": 95}. Offsets are in seconds.
+type JSON struct {
+	Medium
+}
+
+type jsonMedium struct {
+	URI   string  `json:"uri"`
+	Start float64 `json:"start,omitempty"`
+	End   float64 `json:"end,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if j.Medium == nil {
+		return []byte("null"), nil
+	}
+	start, end := Range(j.Medium)
+	return json.Marshal(jsonMedium{
+		URI:   URI(j.Medium),
+		Start: start.Seconds(),
+		End:   end.Seconds(),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (j *JSON) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		j.Medium = nil
+		return nil
+	}
+	var v jsonMedium
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	m, err := ParseURI(v.URI)
+	if err != nil {
+		return err
+	}
+	if v.Start != 0 || v.End != 0 {
+		m = NewClip(m, seconds(v.Start), seconds(v.End))
+	}
+	j.Medium = m
+	return nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -1,13 +1,30 @@
 package medium
 
 import (
+	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
+	"time"
 )
 
 // ProviderYouTube is the provider for youtube videos.
 var ProviderYouTube = simpleProvider("youtube")
 
+func init() {
+	Register(Registration{
+		Provider: ProviderYouTube,
+		Hosts: []string{
+			"youtube.com", "www.youtube.com", "m.youtube.com", "music.youtube.com",
+			"youtu.be", "www.youtu.be",
+			"youtube-nocookie.com", "www.youtube-nocookie.com",
+		},
+		Parse:           NewYouTubeVideoFromURL,
+		ParseCollection: NewYouTubePlaylistFromURL,
+		ParseID:         newYouTubeMedium,
+	})
+}
+
 type youTubeVideo string
 
 func (m youTubeVideo) Provider() Provider {
@@ -18,25 +35,141 @@ func (m youTubeVideo) ID() interface{} {
 	return string(m)
 }
 
+func (m youTubeVideo) URL() string {
+	return "https://www.youtube.com/watch?v=" + string(m)
+}
+
+// clipURL returns an url that only plays the range. Only embed urls support
+// an end.
+func (m youTubeVideo) clipURL(start, end time.Duration) string {
+	if end != 0 {
+		return fmt.Sprintf("https://www.youtube.com/embed/%s?start=%d&end=%d", m, int(start.Seconds()), int(end.Seconds()))
+	}
+	return fmt.Sprintf("%s&t=%ds", m.URL(), int(start.Seconds()))
+}
+
+type youTubePlaylist string
+
+func (m youTubePlaylist) Provider() Provider {
+	return ProviderYouTube
+}
+
+func (m youTubePlaylist) ID() interface{} {
+	return string(m)
+}
+
+func (m youTubePlaylist) URL() string {
+	return "https://www.youtube.com/playlist?list=" + string(m)
+}
+
+func (m youTubePlaylist) IsCollection() bool {
+	return true
+}
+
+var (
+	youTubeID         = regexp.MustCompile(`^[0-9A-Za-z_-]{11}$`)
+	youTubePlaylistID = regexp.MustCompile(`^[0-9A-Za-z_-]{12,}$`)
+)
+
 // NewYouTubeVideo returns a new medium that is a YouTube video.
 func NewYouTubeVideo(videoID string) (Medium, error) {
+	if !youTubeID.MatchString(videoID) {
+		return nil, ErrInvalidURL
+	}
 	return youTubeVideo(videoID), nil
 }
 
+// newYouTubeMedium returns a video or a playlist, depending on the id.
+func newYouTubeMedium(id string) (Medium, error) {
+	if youTubeID.MatchString(id) {
+		return youTubeVideo(id), nil
+	}
+	return NewYouTubePlaylist(id)
+}
+
+// NewYouTubePlaylist returns a new medium that is a YouTube playlist.
+func NewYouTubePlaylist(playlistID string) (Collection, error) {
+	if !youTubePlaylistID.MatchString(playlistID) {
+		return nil, ErrInvalidURL
+	}
+	return youTubePlaylist(playlistID), nil
+}
+
+// NewYouTubePlaylistFromURL returns a new medium that is the YouTube playlist
+// given by the list parameter of the url. Use it to get the playlist of a
+// video url that also has a list parameter.
+func NewYouTubePlaylistFromURL(url *url.URL) (Medium, error) {
+	return NewYouTubePlaylist(url.Query().Get("list"))
+}
+
 // NewYouTubeVideoFromURL returns a new medium that is a YouTube video from a
-// url.
+// url. Besides watch urls it understands short links and the shorts, embed,
+// live, v and attribution_link forms. If the url has a t, start or end
+// parameter, the medium is a clip. Urls of playlists without a video return
+// the playlist.
 func NewYouTubeVideoFromURL(url *url.URL) (Medium, error) {
-	// if the url contains a v=videoID parameter, we'll take that
-	if v := url.Query().Get("v"); v != "" {
-		return youTubeVideo(v), nil
-	}
-	// assume video id is in path, take the first part that is long enough
-	path := strings.Split(url.Path, "/")
-	for _, p := range path {
-		if len(p) >= 11 { // assuming id is always at least 11 chars
-			return youTubeVideo(p), nil
+	m, err := youTubeVideoFromURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if start, end := youTubeRange(url); (start != 0 || end != 0) && !IsCollection(m) {
+		m = NewClip(m, start, end)
+	}
+	return m, nil
+}
+
+func youTubeVideoFromURL(url *url.URL) (Medium, error) {
+	parts := strings.Split(strings.Trim(url.Path, "/"), "/")
+
+	// short links have the video id as the only path part
+	if host := strings.ToLower(url.Hostname()); host == "youtu.be" || host == "www.youtu.be" {
+		if len(parts) != 1 {
+			return nil, ErrInvalidURL
 		}
+		return NewYouTubeVideo(parts[0])
+	}
+
+	switch parts[0] {
+	case "watch":
+		if v := url.Query().Get("v"); v != "" || url.Query().Get("list") == "" {
+			return NewYouTubeVideo(v)
+		}
+		return NewYouTubePlaylistFromURL(url)
+	case "playlist":
+		return NewYouTubePlaylistFromURL(url)
+	case "embed":
+		if len(parts) == 2 && parts[1] == "videoseries" {
+			return NewYouTubePlaylistFromURL(url)
+		}
+		fallthrough
+	case "shorts", "live", "v", "e":
+		if len(parts) < 2 {
+			return nil, ErrInvalidURL
+		}
+		return NewYouTubeVideo(parts[1])
+	case "attribution_link":
+		// the actual video url is in the u parameter, relative to this one
+		u, err := url.Parse(url.Query().Get("u"))
+		if err != nil || u.Host != url.Host || strings.Trim(u.Path, "/") == "attribution_link" {
+			return nil, ErrInvalidURL
+		}
+		return NewYouTubeVideoFromURL(u)
 	}
 
 	return nil, ErrInvalidURL
 }
+
+// youTubeRange returns the time range given by the t or start and the end
+// parameters. The t parameter may also be in the fragment.
+func youTubeRange(u *url.URL) (start, end time.Duration) {
+	query := u.Query()
+	fragment, _ := url.ParseQuery(u.Fragment)
+	for _, t := range []string{query.Get("t"), query.Get("start"), fragment.Get("t")} {
+		if d, ok := parseTimestamp(t); ok {
+			start = d
+			break
+		}
+	}
+	end, _ = parseTimestamp(query.Get("end"))
+	return start, end
+}
@@ -0,0 +1,100 @@
+package medium
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// YouTubeAPIBaseURL is the base url of the YouTube Data API.
+const YouTubeAPIBaseURL = "https://www.googleapis.com/youtube/v3"
+
+// YouTubePlaylistResolver resolves YouTube playlists using the YouTube Data
+// API.
+type YouTubePlaylistResolver struct {
+	// APIKey is the key used to access the api.
+	APIKey string
+	// BaseURL is the base url of the api. It defaults to YouTubeAPIBaseURL.
+	BaseURL string
+	// Client is used for the requests. It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Resolve returns at most max videos of the playlist. Videos that are not
+// available, e.g. because they are private, are skipped.
+func (r *YouTubePlaylistResolver) Resolve(ctx context.Context, c Collection, max int) ([]Medium, error) {
+	playlist, ok := c.(youTubePlaylist)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	var media []Medium
+	pageToken := ""
+	for len(media) < max {
+		var page struct {
+			NextPageToken string `json:"nextPageToken"`
+			Items         []struct {
+				ContentDetails struct {
+					VideoID string `json:"videoId"`
+				} `json:"contentDetails"`
+			} `json:"items"`
+		}
+		err := r.get(ctx, "playlistItems", url.Values{
+			"part":       {"contentDetails"},
+			"playlistId": {string(playlist)},
+			"maxResults": {strconv.Itoa(clamp(max-len(media), 1, 50))},
+			"pageToken":  {pageToken},
+		}, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			if m, err := NewYouTubeVideo(item.ContentDetails.VideoID); err == nil && len(media) < max {
+				media = append(media, m)
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	return media, nil
+}
+
+// get requests the api resource and decodes the response into v.
+func (r *YouTubePlaylistResolver) get(ctx context.Context, resource string, params url.Values, v interface{}) error {
+	baseURL, client := r.BaseURL, r.Client
+	if baseURL == "" {
+		baseURL = YouTubeAPIBaseURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	params.Set("key", r.APIKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/"+resource+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("youtube api responded with %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
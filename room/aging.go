@@ -0,0 +1,34 @@
+package room
+
+import (
+	"math"
+	"time"
+)
+
+// Aging lets votes lose weight over time and media gain score while they
+// wait in the queue, so that old votes do not decide the order forever. The
+// zero value disables aging and the effective score equals the votes.
+type Aging struct {
+	// HalfLife is how long it takes a vote to lose half of its weight. Votes
+	// keep their weight if it is 0.
+	HalfLife time.Duration
+	// PointsPerHour is the score media gain per hour of waiting.
+	PointsPerHour float64
+}
+
+// effectiveScore returns the score of the medium at the time.
+func (a Aging) effectiveScore(info *mediumInfo, now time.Time) float64 {
+	var score float64
+	for user, gravity := range info.votes {
+		score += float64(gravity) * a.weight(now.Sub(info.votedAt[user]))
+	}
+	return score + a.PointsPerHour*now.Sub(info.addedAt).Hours()
+}
+
+// weight returns the weight of a vote of the age.
+func (a Aging) weight(age time.Duration) float64 {
+	if a.HalfLife <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(age)/float64(a.HalfLife))
+}
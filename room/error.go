@@ -1,10 +1,81 @@
 package room
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+)
 
 // errors
 var (
 	ErrUserUnknown         = errors.New("user unknown")
 	ErrMediumUnknown       = errors.New("medium unknown")
 	ErrMediumAlreadyExists = errors.New("medium already exists")
+	ErrMediumIsCollection  = errors.New("medium is a collection")
+	ErrCollectionsDisabled = errors.New("collections are disabled")
+	ErrNothingQueued       = errors.New("nothing in the collection could be queued")
+	ErrSkippingDisabled    = errors.New("skipping is disabled")
 )
+
+// ProbableRepostError is returned if a medium is probably the same song as a
+// queued or recently played medium of another provider.
+type ProbableRepostError struct {
+	// Medium is the medium that was not queued.
+	Medium medium.Medium
+	// Original is the queued medium and Metadata its metadata.
+	Original medium.Medium
+	Metadata metadata.Metadata
+}
+
+func (e *ProbableRepostError) Error() string {
+	return "probable repost of " + e.Metadata.String()
+}
+
+// RecentlyPlayedError is returned if a medium was played within the repost
+// cooldown of the room.
+type RecentlyPlayedError struct {
+	Play Play
+}
+
+func (e *RecentlyPlayedError) Error() string {
+	return "medium was played recently"
+}
+
+// PolicyViolationError is returned if a medium violates a rule of the policy
+// of the room.
+type PolicyViolationError struct {
+	// Rule is the violated rule.
+	Rule Rule
+	// Reason describes the violation, e.g. "longer than 10:00".
+	Reason string
+}
+
+func (e *PolicyViolationError) Error() string {
+	return "policy violation: " + e.Reason
+}
+
+// QuotaExceededError is returned if a user already has the maximum number of
+// media in the queue. The user can queue again once one of them was played.
+type QuotaExceededError struct {
+	Max int
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("user has %d media queued already", e.Max)
+}
+
+// RateLimitError is returned if a user queued the maximum number of media
+// within the window.
+type RateLimitError struct {
+	Max    int
+	Window time.Duration
+	// RetryAt is when the user can queue again.
+	RetryAt time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("user queued %d media within %s already", e.Max, e.Window)
+}
@@ -0,0 +1,4 @@
+package room
+
+// NewWithClock returns a room that uses the clock instead of time.Now.
+var NewWithClock = newRoom
@@ -0,0 +1,88 @@
+package room
+
+import (
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+)
+
+// historySize is how many plays a room remembers at least. Plays within the
+// repost cooldown are remembered, too.
+const historySize = 100
+
+// Play is a medium that was played in a room.
+type Play struct {
+	Medium   medium.Medium
+	Metadata metadata.Metadata
+	// User is the user that queued the medium.
+	User interface{}
+	// Score is the score of the medium when it ended.
+	Score   int
+	AddedAt time.Time
+	// PlayedAt is when the medium was dispatched to the player.
+	PlayedAt time.Time
+	EndedAt  time.Time
+	// State is Finished or Skipped.
+	State State
+}
+
+// SetRepostCooldown sets how long a played medium cannot be queued again. A
+// cooldown of 0 allows queuing it right after it was played.
+func (r *Room) SetRepostCooldown(cooldown time.Duration) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.repostCooldown = cooldown
+}
+
+// History returns the media that were played, most recent first.
+func (r *Room) History() []Play {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	plays := make([]Play, len(r.history))
+	for i, play := range r.history {
+		plays[len(plays)-1-i] = play
+	}
+	return plays
+}
+
+// recordPlay adds the medium that ended in the state to the history if it was
+// played. Media that were skipped before they were dispatched were not played.
+// The caller must hold the lock.
+func (r *Room) recordPlay(m medium.Medium, info *mediumInfo, state State) {
+	if state != Finished && state != Skipped || info.dispatchedAt.IsZero() {
+		return
+	}
+	now := r.now()
+	play := Play{
+		Medium:   m,
+		Metadata: info.metadata,
+		User:     info.user,
+		Score:    info.score,
+		AddedAt:  info.addedAt,
+		PlayedAt: info.dispatchedAt,
+		EndedAt:  now,
+		State:    state,
+	}
+	r.history = append(r.history, play)
+
+	// forget old plays outside of the cooldown
+	for len(r.history) > historySize && !r.withinCooldown(r.history[0], now) {
+		r.history = r.history[1:]
+	}
+}
+
+// recentPlays returns the plays within the repost cooldown, most recent
+// first. The caller must hold the lock.
+func (r *Room) recentPlays() []Play {
+	now := r.now()
+	var plays []Play
+	for i := len(r.history) - 1; i >= 0 && r.withinCooldown(r.history[i], now); i-- {
+		plays = append(plays, r.history[i])
+	}
+	return plays
+}
+
+func (r *Room) withinCooldown(play Play, now time.Time) bool {
+	return now.Sub(play.EndedAt) < r.repostCooldown
+}
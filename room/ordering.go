@@ -0,0 +1,210 @@
+package room
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+)
+
+// QueueItem is a queued medium as seen by ordering strategies.
+type QueueItem struct {
+	Medium medium.Medium
+	// User is the user that queued the medium.
+	User interface{}
+	// Score is the sum of the votes.
+	Score int
+	// EffectiveScore is the score after aging. It is what the queue is
+	// ordered by.
+	EffectiveScore float64
+	AddedAt        time.Time
+	// UserLastPlayedAt is when a medium of the user was played last. It is
+	// zero if none was played, yet.
+	UserLastPlayedAt time.Time
+	// UserStreak is how many media of the user were played in a row most
+	// recently. It is 0 if the last played medium was not the user's.
+	UserStreak int
+}
+
+// OrderingStrategy decides the order in which queued media are played. It
+// must be safe for concurrent use.
+type OrderingStrategy interface {
+	// Order sorts the items in the order they are supposed to be played.
+	Order(items []QueueItem)
+	// String returns the name of the strategy.
+	String() string
+}
+
+// ByScore plays the media with the highest effective score first and media
+// with the same score in the order they were added. It is the default
+// strategy.
+type ByScore struct{}
+
+func (ByScore) Order(items []QueueItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].EffectiveScore != items[j].EffectiveScore {
+			return items[i].EffectiveScore > items[j].EffectiveScore
+		}
+		return items[i].AddedAt.Before(items[j].AddedAt)
+	})
+}
+
+func (ByScore) String() string {
+	return "score"
+}
+
+// FIFO plays the media in the order they were added, regardless of votes.
+type FIFO struct{}
+
+func (FIFO) Order(items []QueueItem) {
+	sortByAge(items)
+}
+
+func (FIFO) String() string {
+	return "fifo"
+}
+
+// RoundRobin takes turns between the users that queued media, so that no
+// user can drown out the others by queuing many media. Each turn, a user
+// plays up to MaxConsecutive of their media, which are ordered by effective
+// score and then by age. The user whose media were played the longest ago
+// goes first. Users only play more media in a row if nobody else is waiting.
+type RoundRobin struct {
+	// MaxConsecutive is how many media a user may play in a row. It defaults
+	// to 1.
+	MaxConsecutive int
+}
+
+func (rr RoundRobin) Order(items []QueueItem) {
+	max := rr.MaxConsecutive
+	if max < 1 {
+		max = 1
+	}
+
+	// order the media of each user by score and age
+	ByScore{}.Order(items)
+	var users []*turn
+	turns := make(map[interface{}]*turn)
+	for _, item := range items {
+		t, ok := turns[item.User]
+		if !ok {
+			t = &turn{max: max}
+			turns[item.User] = t
+			users = append(users, t)
+		}
+		t.items = append(t.items, item)
+		if t.oldest.IsZero() || item.AddedAt.Before(t.oldest) {
+			t.oldest = item.AddedAt
+		}
+		t.lastPlayedAt, t.streak = item.UserLastPlayedAt, item.UserStreak
+	}
+
+	// the user whose turn is not over goes first, then the one that waited
+	// the longest
+	sort.SliceStable(users, func(i, j int) bool {
+		if ui, uj := users[i].continues(), users[j].continues(); ui != uj {
+			return ui
+		}
+		if !users[i].lastPlayedAt.Equal(users[j].lastPlayedAt) {
+			return users[i].lastPlayedAt.Before(users[j].lastPlayedAt)
+		}
+		return users[i].oldest.Before(users[j].oldest)
+	})
+	if len(users) > 0 && users[0].continues() {
+		users[0].max -= users[0].streak
+	}
+
+	// take turns
+	items = items[:0]
+	for len(users) > 0 {
+		next := users[:0]
+		for _, t := range users {
+			n := t.max
+			if n > len(t.items) || len(users) == 1 {
+				n = len(t.items)
+			}
+			items = append(items, t.items[:n]...)
+			t.items, t.max = t.items[n:], max
+			if len(t.items) > 0 {
+				next = append(next, t)
+			}
+		}
+		users = next
+	}
+}
+
+func (RoundRobin) String() string {
+	return "roundrobin"
+}
+
+// turn is a user taking turns.
+type turn struct {
+	items        []QueueItem
+	oldest       time.Time
+	lastPlayedAt time.Time
+	streak       int
+	max          int
+}
+
+// continues returns whether the user played last and their turn is not over.
+func (t *turn) continues() bool {
+	return t.streak > 0 && t.streak < t.max
+}
+
+// WeightedShuffle plays the media in random order, but each point of score
+// doubles the chance of a medium to be played before others and each negative
+// point halves it. It uses the score rather than the effective score, so that
+// the order only changes when votes change and not as time passes.
+type WeightedShuffle struct {
+	l    sync.Mutex
+	rand *rand.Rand
+	keys map[medium.Medium]float64
+}
+
+// NewWeightedShuffle returns a weighted shuffle that uses the seed to shuffle.
+func NewWeightedShuffle(seed int64) *WeightedShuffle {
+	return &WeightedShuffle{
+		rand: rand.New(rand.NewSource(seed)),
+		keys: make(map[medium.Medium]float64),
+	}
+}
+
+func (s *WeightedShuffle) Order(items []QueueItem) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	// draw a random number per medium once, in the order they were added to
+	// keep it reproducible
+	sortByAge(items)
+	keys := make(map[medium.Medium]float64, len(items))
+	for _, item := range items {
+		u, ok := s.keys[item.Medium]
+		if !ok {
+			u = 1 - s.rand.Float64() // (0, 1]
+		}
+		keys[item.Medium] = u
+	}
+	s.keys = keys // forget media that are gone
+
+	// weighted random sampling by sorting by u^(1/weight)
+	sort.SliceStable(items, func(i, j int) bool {
+		return s.key(items[i]) > s.key(items[j])
+	})
+}
+
+func (s *WeightedShuffle) key(item QueueItem) float64 {
+	weight := math.Pow(2, float64(item.Score))
+	return math.Pow(s.keys[item.Medium], 1/weight)
+}
+
+func (s *WeightedShuffle) String() string {
+	return "shuffle"
+}
+
+func sortByAge(items []QueueItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].AddedAt.Before(items[j].AddedAt)
+	})
+}
@@ -0,0 +1,288 @@
+package room
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+)
+
+// Rule is a rule of the policy of a room that decides whether a medium may be
+// queued.
+type Rule interface {
+	// Check returns a *PolicyViolationError if the medium must not be queued.
+	Check(m medium.Medium, md metadata.Metadata) error
+	// String describes the rule, e.g. "at most 10:00 long".
+	String() string
+}
+
+// MediumRule is a rule that decides by the medium alone. It is checked before
+// the metadata of the medium is resolved, so that rejected media do not cost a
+// request.
+type MediumRule interface {
+	Rule
+	// CheckMedium is like Check, but without the metadata.
+	CheckMedium(m medium.Medium) error
+}
+
+// MaxDuration is a rule that rejects media that play longer than the
+// duration. Clips are judged by their range. Media of unknown duration are
+// allowed.
+type MaxDuration time.Duration
+
+func (d MaxDuration) Check(m medium.Medium, md metadata.Metadata) error {
+	if playtime(m, md) > time.Duration(d) {
+		return &PolicyViolationError{d, "longer than " + metadata.FormatDuration(time.Duration(d))}
+	}
+	return nil
+}
+
+func (d MaxDuration) String() string {
+	return "at most " + metadata.FormatDuration(time.Duration(d)) + " long"
+}
+
+// MinDuration is a rule that rejects media that play shorter than the
+// duration. Clips are judged by their range. Media of unknown duration are
+// allowed.
+type MinDuration time.Duration
+
+func (d MinDuration) Check(m medium.Medium, md metadata.Metadata) error {
+	if t := playtime(m, md); t > 0 && t < time.Duration(d) {
+		return &PolicyViolationError{d, "shorter than " + metadata.FormatDuration(time.Duration(d))}
+	}
+	return nil
+}
+
+func (d MinDuration) String() string {
+	return "at least " + metadata.FormatDuration(time.Duration(d)) + " long"
+}
+
+// KnownDuration is a rule that rejects media of unknown duration, which the
+// other duration rules allow.
+type KnownDuration struct{}
+
+func (KnownDuration) Check(m medium.Medium, md metadata.Metadata) error {
+	if playtime(m, md) == 0 {
+		return &PolicyViolationError{KnownDuration{}, "its duration is unknown"}
+	}
+	return nil
+}
+
+func (KnownDuration) String() string {
+	return "of known duration"
+}
+
+// playtime returns how long the medium plays or 0 if that is unknown.
+func playtime(m medium.Medium, md metadata.Metadata) time.Duration {
+	start, end := medium.Range(m)
+	switch {
+	case end > 0:
+		return end - start
+	case md.Duration > start:
+		return md.Duration - start
+	}
+	return 0
+}
+
+// AllowedProviders is a rule that only allows media of the providers with the
+// names.
+type AllowedProviders []string
+
+func (p AllowedProviders) Check(m medium.Medium, _ metadata.Metadata) error {
+	return p.CheckMedium(m)
+}
+
+func (p AllowedProviders) CheckMedium(m medium.Medium) error {
+	for _, name := range p {
+		if strings.EqualFold(name, m.Provider().String()) {
+			return nil
+		}
+	}
+	return &PolicyViolationError{p, m.Provider().String() + " is not allowed"}
+}
+
+func (p AllowedProviders) String() string {
+	return "only from " + strings.Join(p, ", ")
+}
+
+// BlockedMedia is a rule that rejects the media, including clips of them.
+type BlockedMedia []medium.Medium
+
+func (b BlockedMedia) Check(m medium.Medium, _ metadata.Metadata) error {
+	return b.CheckMedium(m)
+}
+
+func (b BlockedMedia) CheckMedium(m medium.Medium) error {
+	for _, blocked := range b {
+		if medium.Identical(m, blocked) {
+			return &PolicyViolationError{b, "it is blocked"}
+		}
+	}
+	return nil
+}
+
+func (b BlockedMedia) String() string {
+	if len(b) == 1 {
+		return "not the blocked medium"
+	}
+	return "none of the " + strconv.Itoa(len(b)) + " blocked media"
+}
+
+// BlockedArtists is a rule that rejects media of the artists, channels or
+// uploaders. Names are compared case insensitively.
+type BlockedArtists []string
+
+func (b BlockedArtists) Check(_ medium.Medium, md metadata.Metadata) error {
+	for _, artist := range b {
+		if strings.EqualFold(strings.TrimSpace(md.Artist), artist) {
+			return &PolicyViolationError{b, md.Artist + " is blocked"}
+		}
+	}
+	return nil
+}
+
+func (b BlockedArtists) String() string {
+	return "not by " + strings.Join(b, ", ")
+}
+
+// BlockedKeywords is a rule that rejects media with titles that contain any of
+// the keywords, ignoring case.
+type BlockedKeywords []string
+
+func (b BlockedKeywords) Check(_ medium.Medium, md metadata.Metadata) error {
+	title := strings.ToLower(md.Title)
+	for _, keyword := range b {
+		if keyword != "" && strings.Contains(title, strings.ToLower(keyword)) {
+			return &PolicyViolationError{b, "the title contains " + strconv.Quote(keyword)}
+		}
+	}
+	return nil
+}
+
+func (b BlockedKeywords) String() string {
+	return "without " + strings.Join(b, ", ") + " in the title"
+}
+
+// ParseRule parses a rule like "max 10:00", "min 30s", "providers youtube
+// soundcloud", "block <link>", "artist <name>", "keyword <word>" or "known".
+// Durations are given as "m:ss", "h:mm:ss" or like "10m". Blocked media,
+// artists and keywords are parsed one at a time.
+func ParseRule(s string) (Rule, error) {
+	kind, arg := strings.TrimSpace(s), ""
+	if i := strings.IndexAny(kind, " \t\n"); i >= 0 {
+		kind, arg = kind[:i], strings.TrimSpace(kind[i+1:])
+	}
+	switch strings.ToLower(kind) {
+	case "max", "min":
+		d, err := parseDuration(arg)
+		if err != nil {
+			return nil, err
+		}
+		if strings.ToLower(kind) == "max" {
+			return MaxDuration(d), nil
+		}
+		return MinDuration(d), nil
+	case "providers":
+		names := strings.Fields(strings.ToLower(arg))
+		if len(names) == 0 {
+			return nil, errors.New("no providers given")
+		}
+		return AllowedProviders(names), nil
+	case "block":
+		m, err := medium.New(arg)
+		if err != nil {
+			if m, err = medium.ParseURI(arg); err != nil {
+				return nil, errors.New("unsupported link: " + arg)
+			}
+		}
+		return BlockedMedia{m}, nil
+	case "artist", "keyword":
+		if arg == "" {
+			return nil, errors.New("no " + strings.ToLower(kind) + " given")
+		}
+		if strings.ToLower(kind) == "artist" {
+			return BlockedArtists{arg}, nil
+		}
+		return BlockedKeywords{arg}, nil
+	case "known":
+		return KnownDuration{}, nil
+	}
+	return nil, errors.New("unknown rule: " + kind)
+}
+
+// parseDuration parses a positive duration like "10m", "3:30" or "1:00:00".
+func parseDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		d = 0
+		for _, part := range strings.Split(s, ":") {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return 0, errors.New("invalid duration: " + s)
+			}
+			d = d*60 + time.Duration(n)*time.Second
+		}
+	}
+	if d <= 0 {
+		return 0, errors.New("invalid duration: " + s)
+	}
+	return d, nil
+}
+
+// FormatRule returns the rule in the form that ParseRule parses. Rules that
+// block several media, artists or keywords are returned one per line. It
+// returns false if ParseRule does not know the rule.
+func FormatRule(rule Rule) ([]string, bool) {
+	var lines []string
+	switch r := rule.(type) {
+	case MaxDuration:
+		lines = append(lines, "max "+time.Duration(r).String())
+	case MinDuration:
+		lines = append(lines, "min "+time.Duration(r).String())
+	case AllowedProviders:
+		lines = append(lines, "providers "+strings.Join(r, " "))
+	case BlockedMedia:
+		for _, m := range r {
+			lines = append(lines, "block "+medium.URI(m))
+		}
+	case BlockedArtists:
+		for _, artist := range r {
+			lines = append(lines, "artist "+artist)
+		}
+	case BlockedKeywords:
+		for _, keyword := range r {
+			lines = append(lines, "keyword "+keyword)
+		}
+	case KnownDuration:
+		lines = append(lines, "known")
+	default:
+		return nil, false
+	}
+	return lines, true
+}
+
+// WithRule returns the rules with the rule added. It replaces a rule of the
+// same kind, except that blocked media, artists and keywords are added to
+// those of the existing rule.
+func WithRule(rules []Rule, rule Rule) []Rule {
+	result := make([]Rule, 0, len(rules)+1)
+	for _, existing := range rules {
+		if reflect.TypeOf(existing) != reflect.TypeOf(rule) {
+			result = append(result, existing)
+			continue
+		}
+		switch r := rule.(type) {
+		case BlockedMedia:
+			rule = append(append(BlockedMedia(nil), existing.(BlockedMedia)...), r...)
+		case BlockedArtists:
+			rule = append(append(BlockedArtists(nil), existing.(BlockedArtists)...), r...)
+		case BlockedKeywords:
+			rule = append(append(BlockedKeywords(nil), existing.(BlockedKeywords)...), r...)
+		}
+	}
+	return append(result, rule)
+}
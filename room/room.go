@@ -1,11 +1,12 @@
 package room
 
 import (
-	"sort"
+	"context"
 	"sync"
 	"time"
 
 	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
 )
 
 // Room is a room where media is played.
@@ -13,13 +14,53 @@ type Room struct {
 	l     sync.RWMutex
 	users map[interface{}]*userInfo
 	media map[medium.Medium]*mediumInfo
+
+	collectionResolver medium.CollectionResolver
+	maxCollectionSize  int
+	metadataResolver   metadata.Resolver
+	probableReposts    bool
+	policy             []Rule
+	ordering           OrderingStrategy
+	limits             Limits
+	aging              Aging
+	skipThreshold      SkipThreshold
+	repostCooldown     time.Duration
+
+	// the medium that was dispatched to the player last and did not end
+	current medium.Medium
+	// receiving media skipped while they were current
+	skipWatchers map[chan medium.Medium]struct{}
+
+	// the user whose media were played last and how many in a row
+	lastPlayedUser interface{}
+	streak         int
+	// played media, oldest first
+	history []Play
+
+	now func() time.Time
 }
 
+const (
+	// metadataTimeout is how long resolving the metadata of a medium may
+	// take.
+	metadataTimeout = 5 * time.Second
+	// metadataWorkers is how many media of a collection are resolved at
+	// once.
+	metadataWorkers = 8
+)
+
 // New creates a new room.
 func New() *Room {
+	return newRoom(time.Now)
+}
+
+func newRoom(now func() time.Time) *Room {
 	return &Room{
-		users: make(map[interface{}]*userInfo),
-		media: make(map[medium.Medium]*mediumInfo),
+		users:        make(map[interface{}]*userInfo),
+		media:        make(map[medium.Medium]*mediumInfo),
+		ordering:     ByScore{},
+		skipWatchers: make(map[chan medium.Medium]struct{}),
+		now:          now,
 	}
 }
 
@@ -32,61 +73,566 @@ func (r *Room) UserJoins(user interface{}) {
 	}
 }
 
-// UserLeaves removes a user from the room.
+// UserLeaves removes a user from the room. Their queued media are removed,
+// but the current medium keeps playing.
 func (r *Room) UserLeaves(user interface{}) {
 	r.l.Lock()
 	defer r.l.Unlock()
 	delete(r.users, user)
 	// remove all media and votes of that user
 	for m, info := range r.media {
-		if info.user == user {
-			// inform that the medium was removed
-			info.played <- ErrMediumUnknown
-			delete(r.media, m)
+		if info.user == user && info.state == Queued {
+			r.end(m, Removed)
 			continue
 		}
 		// remove vote
 		if _, ok := info.votes[user]; ok {
-			info.vote(user, 0)
+			info.vote(user, 0, r.now())
 		}
+		delete(info.skipVotes, user)
+	}
+}
+
+// SetCollectionResolver enables queuing collections of media, which are
+// expanded by the resolver. At most max media are queued per collection. A
+// nil resolver disables collections.
+func (r *Room) SetCollectionResolver(resolver medium.CollectionResolver, max int) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.collectionResolver = resolver
+	r.maxCollectionSize = max
+}
+
+// SetMetadataResolver sets the resolver that is used to resolve the metadata
+// of media when they are queued. A nil resolver disables metadata.
+func (r *Room) SetMetadataResolver(resolver metadata.Resolver) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.metadataResolver = resolver
+}
+
+// SetDetectProbableReposts enables rejecting media that are probably the same
+// song as a queued medium of another provider, judged by their metadata.
+func (r *Room) SetDetectProbableReposts(enabled bool) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.probableReposts = enabled
+}
+
+// SetPolicy sets the rules that queued media must follow. Without rules, all
+// media are allowed.
+func (r *Room) SetPolicy(rules ...Rule) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.policy = append([]Rule(nil), rules...)
+}
+
+// Policy returns the rules that queued media must follow.
+func (r *Room) Policy() []Rule {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	return append([]Rule(nil), r.policy...)
+}
+
+// SetOrdering sets the strategy that decides the order of the queue. A nil
+// strategy orders by score.
+func (r *Room) SetOrdering(ordering OrderingStrategy) {
+	if ordering == nil {
+		ordering = ByScore{}
+	}
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.ordering = ordering
+}
+
+// Limits restrict how many media each user can queue. Zero values do not
+// limit anything.
+type Limits struct {
+	// MaxQueued is how many media a user may have in the queue at once.
+	MaxQueued int
+	// MaxSubmissions is how many media a user may queue within the rolling
+	// Window.
+	MaxSubmissions int
+	Window         time.Duration
+}
+
+// SetLimits sets how many media each user can queue.
+func (r *Room) SetLimits(limits Limits) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.limits = limits
+}
+
+// SetAging sets how votes and waiting time are turned into the effective
+// score that orders the queue.
+func (r *Room) SetAging(aging Aging) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.aging = aging
+}
+
+// SetSkipThreshold sets how many votes are needed to skip the current medium.
+// The zero value disables skipping.
+func (r *Room) SetSkipThreshold(threshold SkipThreshold) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.skipThreshold = threshold
+}
+
+// Ordering returns the strategy that decides the order of the queue.
+func (r *Room) Ordering() OrderingStrategy {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	return r.ordering
+}
+
+// resolveMetadata resolves the metadata of the medium. Metadata is optional,
+// so errors only result in empty metadata. The caller must not hold the lock.
+func (r *Room) resolveMetadata(ctx context.Context, m medium.Medium) metadata.Metadata {
+	r.l.RLock()
+	resolver := r.metadataResolver
+	r.l.RUnlock()
+	if resolver == nil {
+		return metadata.Metadata{}
 	}
+	ctx, cancel := context.WithTimeout(ctx, metadataTimeout)
+	defer cancel()
+	md, err := resolver.Resolve(ctx, m)
+	if err != nil {
+		return metadata.Metadata{}
+	}
+	return md
+}
+
+// resolveAllMetadata resolves the metadata of the media concurrently. The
+// caller must not hold the lock.
+func (r *Room) resolveAllMetadata(ctx context.Context, media []medium.Medium) []metadata.Metadata {
+	mds := make([]metadata.Metadata, len(media))
+	workers := make(chan struct{}, metadataWorkers)
+	var wg sync.WaitGroup
+	for i, m := range media {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, m medium.Medium) {
+			defer wg.Done()
+			mds[i] = r.resolveMetadata(ctx, m)
+			<-workers
+		}(i, m)
+	}
+	wg.Wait()
+	return mds
+}
+
+// UserQueuesMedium adds a medium to the room. The returned channel receives
+// the states of the medium after it was queued and is closed after a terminal
+// state. Collections must be queued with UserQueuesCollection.
+//
+// Media that were played within the repost cooldown are rejected with a
+// *RecentlyPlayedError. If detecting probable reposts is enabled, it returns a
+// *ProbableRepostError if the medium is probably already queued or recently
+// played. Media that violate the policy of the room are rejected with a
+// *PolicyViolationError. Users that exceed the limits of the room get a
+// *QuotaExceededError or a *RateLimitError.
+func (r *Room) UserQueuesMedium(user interface{}, m medium.Medium) (<-chan State, error) {
+	return r.userQueuesMedium(user, m, true)
+}
+
+// UserQueuesMediumAnyway adds a medium to the room like UserQueuesMedium, but
+// even if it is a probable repost.
+func (r *Room) UserQueuesMediumAnyway(user interface{}, m medium.Medium) (<-chan State, error) {
+	return r.userQueuesMedium(user, m, false)
 }
 
-// UserQueuesMedium adds a medium to the room.
-func (r *Room) UserQueuesMedium(user interface{}, m medium.Medium) (<-chan error, error) {
+func (r *Room) userQueuesMedium(user interface{}, m medium.Medium, checkProbableRepost bool) (<-chan State, error) {
+	if medium.IsCollection(m) {
+		return nil, ErrMediumIsCollection
+	}
+	// fail early without resolving the metadata
+	r.l.Lock()
+	err := r.checkEarly(user, m)
+	r.l.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	md := r.resolveMetadata(context.Background(), m)
 	r.l.Lock()
 	defer r.l.Unlock()
-	// get user info
 	if _, ok := r.users[user]; !ok {
+		return nil, ErrUserUnknown // left in the meantime
+	}
+	return r.queue(user, m, md, checkProbableRepost)
+}
+
+// QueuedMedium is a medium that was queued as part of a collection.
+type QueuedMedium struct {
+	Medium medium.Medium
+	// States receives the states of the medium after it was queued. It is
+	// closed after a terminal state.
+	States <-chan State
+}
+
+// UserQueuesCollection expands the collection and adds its media to the room
+// on behalf of the user. Media that are already queued or were played
+// recently, including probable reposts, and media that violate the policy are
+// skipped.
+//
+// Once the user reaches a limit, no more media are queued and the limit error
+// is returned if none were queued at all. If no medium is queued for other
+// reasons, it returns ErrNothingQueued. It returns ErrCollectionsDisabled if
+// the room has no collection resolver.
+func (r *Room) UserQueuesCollection(ctx context.Context, user interface{}, c medium.Collection) ([]QueuedMedium, error) {
+	r.l.RLock()
+	resolver, max := r.collectionResolver, r.maxCollectionSize
+	_, userKnown := r.users[user]
+	r.l.RUnlock()
+	if resolver == nil {
+		return nil, ErrCollectionsDisabled
+	}
+	if !userKnown {
 		return nil, ErrUserUnknown
 	}
-	// check if duplicate
+
+	// resolve without holding the lock
+	resolved, err := resolver.Resolve(ctx, c, max)
+	if err != nil {
+		return nil, err
+	}
+
+	// leave out media that cannot be queued before resolving their metadata
+	r.l.Lock()
+	err = r.checkEarly(user, nil)
+	var media []medium.Medium
+	for _, m := range resolved {
+		if !medium.IsCollection(m) && r.checkDuplicate(m) == nil && r.checkMediumRules(m) == nil {
+			media = append(media, m)
+		}
+	}
+	r.l.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	mds := r.resolveAllMetadata(ctx, media)
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	if _, ok := r.users[user]; !ok {
+		return nil, ErrUserUnknown // left in the meantime
+	}
+	var queued []QueuedMedium
+	for i, m := range media {
+		if len(queued) >= max {
+			break
+		}
+		states, err := r.queue(user, m, mds[i], true)
+		switch err.(type) {
+		case nil:
+			queued = append(queued, QueuedMedium{m, states})
+		case *QuotaExceededError, *RateLimitError:
+			if len(queued) == 0 {
+				return nil, err
+			}
+			return queued, nil
+		}
+	}
+	if len(queued) == 0 {
+		return nil, ErrNothingQueued
+	}
+	return queued, nil
+}
+
+// checkEarly returns the errors that do not depend on the metadata of the
+// medium, so that it does not need to be resolved. A nil medium only checks
+// the user. The caller must hold the lock.
+func (r *Room) checkEarly(user interface{}, m medium.Medium) error {
+	if _, ok := r.users[user]; !ok {
+		return ErrUserUnknown
+	}
+	if m != nil {
+		if err := r.checkDuplicate(m); err != nil {
+			return err
+		}
+		if err := r.checkMediumRules(m); err != nil {
+			return err
+		}
+	}
+	return r.checkLimits(user)
+}
+
+// checkMediumRules returns a *PolicyViolationError if the medium violates a
+// rule of the policy that does not need its metadata. The caller must hold the
+// lock.
+func (r *Room) checkMediumRules(m medium.Medium) error {
+	for _, rule := range r.policy {
+		if rule, ok := rule.(MediumRule); ok {
+			if err := rule.CheckMedium(m); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkDuplicate returns an error if the medium is queued, current or was
+// played recently. The caller must hold the lock.
+func (r *Room) checkDuplicate(m medium.Medium) error {
 	for existing := range r.media {
 		if medium.Identical(m, existing) {
-			return nil, ErrMediumAlreadyExists
+			return ErrMediumAlreadyExists
+		}
+	}
+	for _, play := range r.recentPlays() {
+		if medium.Identical(m, play.Medium) {
+			return &RecentlyPlayedError{Play: play}
+		}
+	}
+	return nil
+}
+
+// queue adds the medium. The caller must hold the lock.
+func (r *Room) queue(user interface{}, m medium.Medium, md metadata.Metadata, checkProbableRepost bool) (<-chan State, error) {
+	if err := r.checkDuplicate(m); err != nil {
+		return nil, err
+	}
+	// check if the same song of another provider
+	if r.probableReposts && checkProbableRepost {
+		for existing, info := range r.media {
+			if existing.Provider() != m.Provider() && metadata.SameSong(md, info.metadata) {
+				return nil, &ProbableRepostError{Medium: m, Original: existing, Metadata: info.metadata}
+			}
 		}
+		for _, play := range r.recentPlays() {
+			if play.Medium.Provider() != m.Provider() && metadata.SameSong(md, play.Metadata) {
+				return nil, &ProbableRepostError{Medium: m, Original: play.Medium, Metadata: play.Metadata}
+			}
+		}
+	}
+	// check policy
+	for _, rule := range r.policy {
+		if err := rule.Check(m, md); err != nil {
+			return nil, err
+		}
+	}
+	// check limits
+	if err := r.checkLimits(user); err != nil {
+		return nil, err
 	}
 	// add medium
 	info := &mediumInfo{
-		user:    user,
-		addedAt: time.Now(),
-		votes:   make(map[interface{}]int),
-		played:  make(chan error, 1),
+		user:      user,
+		metadata:  md,
+		addedAt:   r.now(),
+		votes:     make(map[interface{}]int),
+		votedAt:   make(map[interface{}]time.Time),
+		skipVotes: make(map[interface{}]bool),
+		states:    make(chan State, 3), // dispatched, playing and terminal
 	}
 	r.media[m] = info
-	return info.played, nil
+	if u, ok := r.users[user]; ok && r.limits.MaxSubmissions > 0 {
+		u.submissions = append(u.submissions, info.addedAt)
+	}
+	r.userActive(user)
+	return info.states, nil
 }
 
-// MediumPlayed removes the medium from the room.
-func (r *Room) MediumPlayed(m medium.Medium) {
+// checkLimits returns an error if the user must not queue another medium.
+// The caller must hold the lock.
+func (r *Room) checkLimits(user interface{}) error {
+	if max := r.limits.MaxQueued; max > 0 {
+		queued := 0
+		for _, info := range r.media {
+			if info.user == user && info.state == Queued {
+				queued++
+			}
+		}
+		if queued >= max {
+			return &QuotaExceededError{Max: max}
+		}
+	}
+	u, ok := r.users[user]
+	if max := r.limits.MaxSubmissions; ok && max > 0 {
+		// forget submissions outside of the window
+		since := r.now().Add(-r.limits.Window)
+		for len(u.submissions) > 0 && !u.submissions[0].After(since) {
+			u.submissions = u.submissions[1:]
+		}
+		if len(u.submissions) >= max {
+			retryAt := u.submissions[len(u.submissions)-max].Add(r.limits.Window)
+			return &RateLimitError{Max: max, Window: r.limits.Window, RetryAt: retryAt}
+		}
+	}
+	return nil
+}
+
+// MediumDispatched takes the queued medium out of the queue, because it was
+// sent to the player. It becomes the current medium. The previous current
+// medium is finished.
+func (r *Room) MediumDispatched(m medium.Medium) error {
 	r.l.Lock()
 	defer r.l.Unlock()
-	// inform that the medium was played
-	if info := r.media[m]; info != nil {
-		info.played <- nil
+	info, ok := r.media[m]
+	if !ok || info.state != Queued {
+		return ErrMediumUnknown
+	}
+	if r.current != nil {
+		r.end(r.current, Finished)
 	}
-	// remove medium
+	info.setState(Dispatched)
+	info.dispatchedAt = r.now()
+	r.current = m
+	r.userPlayed(info.user)
+	return nil
+}
+
+// MediumPlaying marks the current medium as started by the player.
+func (r *Room) MediumPlaying(m medium.Medium) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	info, ok := r.media[m]
+	if !ok || r.current != m {
+		return ErrMediumUnknown
+	}
+	if info.state == Dispatched {
+		info.setState(Playing)
+		info.startedAt = r.now()
+	}
+	return nil
+}
+
+// MediumPlayed marks the medium as finished and removes it from the room. The
+// medium does not need to be dispatched before.
+func (r *Room) MediumPlayed(m medium.Medium) error {
+	return r.endMedium(m, Finished)
+}
+
+// MediumSkipped marks the medium as skipped and removes it from the room.
+func (r *Room) MediumSkipped(m medium.Medium) error {
+	return r.endMedium(m, Skipped)
+}
+
+// MediumFailed marks the medium as failed, because the player could not play
+// it, and removes it from the room.
+func (r *Room) MediumFailed(m medium.Medium) error {
+	return r.endMedium(m, Failed)
+}
+
+func (r *Room) endMedium(m medium.Medium, state State) error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	info, ok := r.media[m]
+	if !ok {
+		return ErrMediumUnknown
+	}
+	if info.state == Queued {
+		r.userPlayed(info.user)
+	}
+	r.end(m, state)
+	return nil
+}
+
+// end removes the medium in the terminal state. The caller must hold the
+// lock.
+func (r *Room) end(m medium.Medium, state State) {
+	info := r.media[m]
+	info.setState(state)
+	close(info.states)
 	delete(r.media, m)
+	r.recordPlay(m, info, state)
+	if r.current != m {
+		return
+	}
+	r.current = nil
+	// tell the players to stop
+	if state == Skipped {
+		for watcher := range r.skipWatchers {
+			select {
+			case watcher <- m:
+			default:
+			}
+		}
+	}
+}
+
+// WatchSkips returns a channel that receives the current medium when it is
+// skipped, e.g. by vote, so that players can stop playing it. The returned
+// func stops watching and closes the channel.
+func (r *Room) WatchSkips() (<-chan medium.Medium, func()) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	watcher := make(chan medium.Medium, 1)
+	r.skipWatchers[watcher] = struct{}{}
+	var once sync.Once
+	return watcher, func() {
+		once.Do(func() {
+			r.l.Lock()
+			defer r.l.Unlock()
+			delete(r.skipWatchers, watcher)
+			close(watcher)
+		})
+	}
+}
+
+// UserVotesSkip counts the vote of the user to skip the current medium. The
+// medium is skipped once the skip threshold is reached, which is reported by
+// the returned bool. It returns ErrSkippingDisabled if the room has no skip
+// threshold and ErrMediumUnknown if the medium is not the current one.
+func (r *Room) UserVotesSkip(user interface{}, m medium.Medium) (bool, error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if _, ok := r.users[user]; !ok {
+		return false, ErrUserUnknown
+	}
+	if !r.skipThreshold.enabled() {
+		return false, ErrSkippingDisabled
+	}
+	info, ok := r.media[m]
+	if !ok || r.current != m {
+		return false, ErrMediumUnknown
+	}
+	info.skipVotes[user] = true
+	r.userActive(user)
+	if len(info.skipVotes) < r.skipThreshold.needed(r.activeUsers()) {
+		return false, nil
+	}
+	r.end(m, Skipped)
+	return true, nil
+}
+
+// Current returns the medium that was dispatched to the player last and did
+// not end, yet, and whether there is one.
+func (r *Room) Current() (NowPlaying, bool) {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	if r.current == nil {
+		return NowPlaying{}, false
+	}
+	info := r.media[r.current]
+	votes := make(map[interface{}]int, len(info.votes))
+	for user, gravity := range info.votes {
+		votes[user] = gravity
+	}
+	return NowPlaying{
+		QueueItem:    r.item(r.current, info, r.now()),
+		State:        info.state,
+		DispatchedAt: info.dispatchedAt,
+		StartedAt:    info.startedAt,
+		Votes:        votes,
+		SkipVotes:    len(info.skipVotes),
+		SkipsNeeded:  r.skipThreshold.needed(r.activeUsers()),
+	}, true
+}
+
+// userPlayed remembers that a medium of the user was played. The caller must
+// hold the lock.
+func (r *Room) userPlayed(user interface{}) {
+	if info, ok := r.users[user]; ok {
+		info.lastPlayedAt = r.now()
+	}
+	if r.lastPlayedUser == user {
+		r.streak++
+	} else {
+		r.lastPlayedUser, r.streak = user, 1
+	}
 }
 
 // UserVotesMedium counts a vote that a user casts. The gravity is +1 for an
@@ -103,81 +649,133 @@ func (r *Room) UserVotesMedium(user interface{}, m medium.Medium, gravity int) e
 		return ErrMediumUnknown
 	}
 	// apply vote
-	mediumInfo.vote(user, gravity)
+	mediumInfo.vote(user, gravity, r.now())
+	r.userActive(user)
 	return nil
 }
 
-// GetMediumScore returns the score of the medium and whether the medium exists.
-func (r *Room) GetMediumScore(m medium.Medium) (int, bool) {
+// userActive remembers that the user queued or voted just now. The caller
+// must hold the lock.
+func (r *Room) userActive(user interface{}) {
+	if u, ok := r.users[user]; ok {
+		u.lastActiveAt = r.now()
+	}
+}
+
+// activeUsers returns how many users count as active for the skip threshold.
+// The caller must hold the lock.
+func (r *Room) activeUsers() int {
+	within := r.skipThreshold.ActiveWithin
+	if within <= 0 {
+		return len(r.users)
+	}
+	active := 0
+	for _, u := range r.users {
+		if !u.lastActiveAt.IsZero() && r.now().Sub(u.lastActiveAt) <= within {
+			active++
+		}
+	}
+	return active
+}
+
+// GetMediumScore returns the score of the medium, which is the sum of its
+// votes, its effective score, which the queue is ordered by, and whether the
+// medium exists.
+func (r *Room) GetMediumScore(m medium.Medium) (score int, effective float64, ok bool) {
 	r.l.RLock()
 	defer r.l.RUnlock()
 	// get medium info
 	mediumInfo, ok := r.media[m]
 	if !ok {
-		return 0, false
+		return 0, 0, false
 	}
-	return mediumInfo.score, true
+	return mediumInfo.score, r.aging.effectiveScore(mediumInfo, r.now()), true
 }
 
-// Queue returns the queue of media in the order they are supposed to be played.
-func (r *Room) Queue() []medium.Medium {
+// GetMediumMetadata returns the metadata of the medium and whether the medium
+// exists. The metadata is empty if it could not be resolved.
+func (r *Room) GetMediumMetadata(m medium.Medium) (metadata.Metadata, bool) {
 	r.l.RLock()
 	defer r.l.RUnlock()
-	q := make(mediaQueue, 0, len(r.media))
-	for m, info := range r.media {
-		q = append(q, mediaItem{m, info})
-	}
-	sort.Sort(q)
-	mq := make([]medium.Medium, len(q))
-	for i, item := range q {
-		mq[i] = item.m
+	// get medium info
+	mediumInfo, ok := r.media[m]
+	if !ok {
+		return metadata.Metadata{}, false
 	}
-	return mq
-}
-
-type mediaItem struct {
-	m    medium.Medium
-	info *mediumInfo
-}
-
-type mediaQueue []mediaItem
-
-func (q mediaQueue) Len() int {
-	return len(q)
+	return mediumInfo.metadata, true
 }
 
-func (q mediaQueue) Swap(i, j int) {
-	q[i], q[j] = q[j], q[i]
+// Queue returns the queue of media in the order they are supposed to be
+// played, as decided by the ordering strategy.
+func (r *Room) Queue() []QueueItem {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	now := r.now()
+	items := make([]QueueItem, 0, len(r.media))
+	for m, info := range r.media {
+		if info.state == Queued {
+			items = append(items, r.item(m, info, now))
+		}
+	}
+	r.ordering.Order(items)
+	return items
 }
 
-func (q mediaQueue) Less(i, j int) bool {
-	// compare score
-	if is, js := q[i].info.score, q[j].info.score; is != js {
-		return is > js
+// item returns the medium as queue item. The caller must hold the lock.
+func (r *Room) item(m medium.Medium, info *mediumInfo, now time.Time) QueueItem {
+	item := QueueItem{
+		Medium:         m,
+		User:           info.user,
+		Score:          info.score,
+		EffectiveScore: r.aging.effectiveScore(info, now),
+		AddedAt:        info.addedAt,
 	}
-	// compare age
-	return q[i].info.addedAt.Before(q[j].info.addedAt)
+	if user, ok := r.users[info.user]; ok {
+		item.UserLastPlayedAt = user.lastPlayedAt
+	}
+	if info.user == r.lastPlayedUser {
+		item.UserStreak = r.streak
+	}
+	return item
 }
 
-type userInfo struct{}
+type userInfo struct {
+	lastPlayedAt time.Time
+	lastActiveAt time.Time   // when the user queued or voted last
+	submissions  []time.Time // within the window of the rate limit, oldest first
+}
 
 type mediumInfo struct {
-	user    interface{}
-	addedAt time.Time
-	votes   map[interface{}]int
-	score   int
+	user     interface{}
+	metadata metadata.Metadata
+	addedAt  time.Time
+	votes    map[interface{}]int
+	votedAt  map[interface{}]time.Time
+	score    int
+	// users that voted to skip the medium while it is current
+	skipVotes map[interface{}]bool
 
-	// sending nil if medium was played or ErrMediumUnknown if it was removed
-	played chan error
+	state        State
+	dispatchedAt time.Time
+	startedAt    time.Time
+	// receiving every state after queued, closed after a terminal state
+	states chan State
 }
 
-func (m *mediumInfo) vote(user interface{}, gravity int) {
+func (m *mediumInfo) setState(state State) {
+	m.state = state
+	m.states <- state
+}
+
+func (m *mediumInfo) vote(user interface{}, gravity int, now time.Time) {
 	gravity = clamp(gravity, -1, +1)
 	m.score += gravity - m.votes[user]
 	if gravity == 0 {
 		delete(m.votes, user)
-	} else {
+		delete(m.votedAt, user)
+	} else if m.votes[user] != gravity {
 		m.votes[user] = gravity
+		m.votedAt[user] = now
 	}
 }
 
@@ -1,11 +1,18 @@
 package room_test
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
 	. "github.com/Teelevision/telegram-duebelwein-bot/room"
 )
 
@@ -14,7 +21,7 @@ type testRoom struct {
 }
 
 func (r testRoom) UserQueuesMedium(user interface{}, m medium.Medium) {
-	if err := r.Room.UserQueuesMedium(user, m); err != nil {
+	if _, err := r.Room.UserQueuesMedium(user, m); err != nil {
 		log.Fatalf("did not expect error when adding %q, got %q", m.ID(), err)
 	}
 }
@@ -51,21 +58,55 @@ func ExampleRoom_Queue() {
 	room.UserVotesMedium("Andy", nightWitchesBySabaton, -1)
 	room.UserLeaves("Andy") // is physically kicked for adding cows cows cows
 	room.UserQueuesMedium("Marius", wodkaByDaTweekaz)
-	for i, medium := range room.Queue() {
-		score, _ := room.GetMediumScore(medium)
-		fmt.Printf("#%d %s (Score: %d)\n", i+1, medium.ID(), score)
+	room.UserVotesMedium("Max", wodkaByDaTweekaz, -1)
+	room.UserQueuesMedium("Marius", cowsCowsCows)
+	room.UserQueuesMedium("Max", songBySerj)
+	for _, ordering := range []OrderingStrategy{ByScore{}, FIFO{}, RoundRobin{}, NewWeightedShuffle(1)} {
+		room.SetOrdering(ordering)
+		fmt.Printf("%s:\n", ordering)
+		for i, item := range room.Queue() {
+			fmt.Printf("#%d %s (Score: %d)\n", i+1, item.Medium.ID(), item.Score)
+		}
 	}
 
-	// The song by serj has the most upvotes, but it was removed because Andy
-	// was kicked. Night witches by sabaton has one upvote which is counted, the
-	// downvote by andy was removed when he left, giving it a total of +1 and
-	// thus the next position in the queue. Both other songs have not votes and
-	// therefore are played in the order they were added.
+	// The song by serj had the most upvotes, but it was removed because Andy
+	// was kicked, as were the cows. Both were queued again later without
+	// votes. Night witches by sabaton has one upvote which is counted, the
+	// downvote by andy was removed when he left, giving it a total of +1.
+	//
+	// By score, night witches by sabaton comes first. The songs without votes
+	// are played in the order they were added and wodka by da tweekaz, which
+	// Max downvoted, comes last. FIFO ignores the votes. Round robin takes
+	// turns between Marius and Max until only Marius has songs left. Max goes
+	// first, because a song of Marius was played already, and the downvoted
+	// wodka by da tweekaz is Marius' last song. The weighted shuffle is
+	// random, but favours songs with upvotes.
 
 	// Output:
+	// score:
+	// #1 night witches by sabaton (Score: 1)
+	// #2 another fail compilation (Score: 0)
+	// #3 cows cows cows (Score: 0)
+	// #4 song by serj (Score: 0)
+	// #5 wodka by da tweekaz (Score: -1)
+	// fifo:
+	// #1 another fail compilation (Score: 0)
+	// #2 night witches by sabaton (Score: 1)
+	// #3 wodka by da tweekaz (Score: -1)
+	// #4 cows cows cows (Score: 0)
+	// #5 song by serj (Score: 0)
+	// roundrobin:
 	// #1 night witches by sabaton (Score: 1)
 	// #2 another fail compilation (Score: 0)
-	// #3 wodka by da tweekaz (Score: 0)
+	// #3 song by serj (Score: 0)
+	// #4 cows cows cows (Score: 0)
+	// #5 wodka by da tweekaz (Score: -1)
+	// shuffle:
+	// #1 song by serj (Score: 0)
+	// #2 cows cows cows (Score: 0)
+	// #3 another fail compilation (Score: 0)
+	// #4 night witches by sabaton (Score: 1)
+	// #5 wodka by da tweekaz (Score: -1)
 }
 
 func TestRoom_Queue(t *testing.T) {
@@ -82,13 +123,82 @@ func TestRoom_Queue(t *testing.T) {
 		if len(q) != 2 {
 			t.Fatal("expected queue to contain 2 songs")
 		}
-		if want, got := wodkaByDaTweekaz, q[0]; got != want {
+		if want, got := wodkaByDaTweekaz, q[0].Medium; got != want {
 			t.Fatalf("expected song %q to play first, but got %q", want, got)
 		}
-		if want, got := nightWitchesBySabaton, q[1]; got != want {
+		if want, got := nightWitchesBySabaton, q[1].Medium; got != want {
 			t.Fatalf("expected song %q to play second, but got %q", want, got)
 		}
 	})
+
+	t.Run("round robin takes turns", func(t *testing.T) {
+		a1, a2, a3, a4, a5 := &someMedium{"a1"}, &someMedium{"a2"}, &someMedium{"a3"}, &someMedium{"a4"}, &someMedium{"a5"}
+		b1, b2 := &someMedium{"b1"}, &someMedium{"b2"}
+		room := testRoom{New()}
+		room.SetOrdering(RoundRobin{MaxConsecutive: 2})
+		room.UserJoins("A")
+		room.UserJoins("B")
+		for _, m := range []medium.Medium{a1, a2, a3, a4, a5} {
+			room.UserQueuesMedium("A", m)
+		}
+		room.UserQueuesMedium("B", b1)
+		room.UserQueuesMedium("B", b2)
+		room.UserVotesMedium("B", a4, +1)
+		room.UserVotesMedium("A", b1, -1)
+
+		expectQueue(t, room.Queue(), a4, a1, b2, b1, a2, a3, a5)
+		room.MediumPlayed(a4)
+		expectQueue(t, room.Queue(), a1, b2, b1, a2, a3, a5)
+		room.MediumPlayed(a1)
+		expectQueue(t, room.Queue(), b2, b1, a2, a3, a5)
+		room.MediumPlayed(b2)
+		expectQueue(t, room.Queue(), b1, a2, a3, a5)
+		room.UserQueuesMedium("B", b2)
+		room.MediumPlayed(b1)
+		expectQueue(t, room.Queue(), a2, a3, b2, a5)
+	})
+
+	t.Run("weighted shuffle favours upvotes and keeps its order", func(t *testing.T) {
+		upvotedFirst := 0
+		for seed := int64(0); seed < 100; seed++ {
+			room := testRoom{New()}
+			room.SetOrdering(NewWeightedShuffle(seed))
+			room.UserJoins("A")
+			room.UserQueuesMedium("A", nightWitchesBySabaton)
+			room.UserQueuesMedium("A", wodkaByDaTweekaz)
+			room.UserVotesMedium("A", wodkaByDaTweekaz, +1)
+			q := room.Queue()
+			if q[0].Medium == wodkaByDaTweekaz {
+				upvotedFirst++
+			}
+			if again := room.Queue(); again[0].Medium != q[0].Medium || again[1].Medium != q[1].Medium {
+				t.Fatalf("expected the same order when nothing changed, got %v and %v", q, again)
+			}
+		}
+		// the upvoted song is expected to come first 2 out of 3 times
+		if upvotedFirst < 55 || upvotedFirst > 80 {
+			t.Errorf("expected upvoted song to come first about 67 out of 100 times, got %d", upvotedFirst)
+		}
+	})
+
+	t.Run("weighted shuffle keeps its order while votes age", func(t *testing.T) {
+		for seed := int64(0); seed < 100; seed++ {
+			now := time.Date(2020, 2, 2, 20, 0, 0, 0, time.UTC)
+			room := testRoom{NewWithClock(func() time.Time { return now })}
+			room.SetOrdering(NewWeightedShuffle(seed))
+			room.SetAging(Aging{HalfLife: time.Hour, PointsPerHour: 0.5})
+			room.UserJoins("A")
+			room.UserQueuesMedium("A", nightWitchesBySabaton)
+			now = now.Add(time.Minute)
+			room.UserQueuesMedium("A", wodkaByDaTweekaz)
+			room.UserVotesMedium("A", wodkaByDaTweekaz, +1)
+			q := room.Queue()
+			now = now.Add(5 * time.Hour)
+			if later := room.Queue(); later[0].Medium != q[0].Medium {
+				t.Fatalf("expected the same order after 5 hours with seed %d, got %v and %v", seed, q, later)
+			}
+		}
+	})
 }
 
 func TestRoom_UserQueuesMedium(t *testing.T) {
@@ -96,13 +206,828 @@ func TestRoom_UserQueuesMedium(t *testing.T) {
 		room := New()
 		room.UserJoins(1)
 		room.UserJoins(2)
-		if err := room.UserQueuesMedium(1, &someMedium{"dog video"}); err != nil {
+		if _, err := room.UserQueuesMedium(1, &someMedium{"dog video"}); err != nil {
 			t.Fatalf("did not expect error when adding dog video the first time, got %q", err)
 		}
-		if err := room.UserQueuesMedium(2, &someMedium{"dog video"}); err != ErrMediumAlreadyExists {
+		if _, err := room.UserQueuesMedium(2, &someMedium{"dog video"}); err != ErrMediumAlreadyExists {
 			t.Fatalf("did expect error %q when adding dog video a second time, got %q", ErrMediumAlreadyExists, err)
 		}
 	})
+
+	t.Run("rejects probable reposts if enabled", func(t *testing.T) {
+		video, track, cover := &someMedium{"video"}, &otherMedium{"track"}, &otherMedium{"cover"}
+		room := New()
+		room.SetMetadataResolver(fakeMetadataResolver{
+			video: {Title: "Sabaton - Night Witches (Official Video)", Artist: "Nuclear Blast"},
+			track: {Title: "Night Witches", Artist: "Sabaton"},
+			cover: {Title: "Night Witches", Artist: "Cows"},
+		})
+		room.UserJoins(1)
+		room.UserJoins(2)
+		if _, err := room.UserQueuesMedium(1, video); err != nil {
+			t.Fatalf("did not expect error when adding the video, got %q", err)
+		}
+		if _, err := room.UserQueuesMedium(2, track); err != nil {
+			t.Fatalf("did not expect error when detection is disabled, got %q", err)
+		}
+		room.MediumPlayed(track)
+
+		room.SetDetectProbableReposts(true)
+		_, err := room.UserQueuesMedium(2, track)
+		if err, ok := err.(*ProbableRepostError); !ok || err.Medium != track || err.Original != video {
+			t.Fatalf("expected probable repost error, got %q", err)
+		}
+		if _, err := room.UserQueuesMedium(2, cover); err != nil {
+			t.Fatalf("did not expect error when adding a cover, got %q", err)
+		}
+		if _, err := room.UserQueuesMediumAnyway(2, track); err != nil {
+			t.Fatalf("did not expect error when adding anyway, got %q", err)
+		}
+		if _, err := room.UserQueuesMediumAnyway(2, track); err != ErrMediumAlreadyExists {
+			t.Fatalf("did expect error %q when adding anyway a second time, got %q", ErrMediumAlreadyExists, err)
+		}
+	})
+
+	t.Run("does not resolve the metadata of rejected media", func(t *testing.T) {
+		mds := &countingMetadataResolver{}
+		room := New()
+		room.SetMetadataResolver(mds)
+		room.SetLimits(Limits{MaxQueued: 1})
+		room.UserJoins(1)
+		if _, err := room.UserQueuesMedium(2, failCompilation); err != ErrUserUnknown {
+			t.Fatalf("expected error %q, got %q", ErrUserUnknown, err)
+		}
+		if _, err := room.UserQueuesMedium(1, failCompilation); err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+		if _, err := room.UserQueuesMedium(1, failCompilation); err != ErrMediumAlreadyExists {
+			t.Fatalf("expected error %q, got %q", ErrMediumAlreadyExists, err)
+		}
+		if _, err := room.UserQueuesMedium(1, cowsCowsCows); err == nil {
+			t.Fatal("expected quota exceeded error, got none")
+		}
+		if mds.calls != 1 {
+			t.Fatalf("expected the metadata to be resolved once, got %d times", mds.calls)
+		}
+	})
+
+	t.Run("does not resolve the metadata of media against the policy", func(t *testing.T) {
+		mds := &countingMetadataResolver{}
+		room := New()
+		room.SetMetadataResolver(mds)
+		room.UserJoins(1)
+		for _, rule := range []Rule{BlockedMedia{cowsCowsCows}, AllowedProviders{"youtube"}} {
+			room.SetPolicy(rule)
+			if _, err := room.UserQueuesMedium(1, cowsCowsCows); err == nil {
+				t.Fatalf("expected a policy violation error for rule %q, got none", rule)
+			}
+		}
+		if mds.calls != 0 {
+			t.Fatalf("expected no metadata to be resolved, got %d times", mds.calls)
+		}
+	})
+}
+
+func TestRoom_UserQueuesCollection(t *testing.T) {
+	playlist := &someCollection{"fail compilations"}
+	resolver := fakeResolver{
+		playlist: {failCompilation, anotherFailCompilation, cowsCowsCows, &someCollection{"nested"}},
+	}
+
+	t.Run("collections are disabled by default", func(t *testing.T) {
+		room := New()
+		room.UserJoins(1)
+		if _, err := room.UserQueuesCollection(context.Background(), 1, playlist); err != ErrCollectionsDisabled {
+			t.Fatalf("expected error %q, got %q", ErrCollectionsDisabled, err)
+		}
+	})
+
+	t.Run("collections cannot be queued as medium", func(t *testing.T) {
+		room := New()
+		room.SetCollectionResolver(resolver, 10)
+		room.UserJoins(1)
+		if _, err := room.UserQueuesMedium(1, playlist); err != ErrMediumIsCollection {
+			t.Fatalf("expected error %q, got %q", ErrMediumIsCollection, err)
+		}
+	})
+
+	t.Run("queues media of the collection", func(t *testing.T) {
+		room := testRoom{New()}
+		room.SetCollectionResolver(resolver, 10)
+		room.UserJoins("A")
+		room.UserJoins("B")
+		room.UserQueuesMedium("B", anotherFailCompilation)
+		queued, err := room.UserQueuesCollection(context.Background(), "A", playlist)
+		if err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+		// the duplicate and the nested collection are skipped
+		if len(queued) != 2 || queued[0].Medium != failCompilation || queued[1].Medium != cowsCowsCows {
+			t.Fatalf("expected fail compilation and cows cows cows to be queued, got %v", queued)
+		}
+		// the media belong to the user that queued the collection
+		room.UserLeaves("A")
+		if q := room.Queue(); len(q) != 1 || q[0].Medium != anotherFailCompilation {
+			t.Fatalf("expected only another fail compilation to be left, got %v", q)
+		}
+		if state := <-queued[0].States; state != Removed {
+			t.Fatalf("expected media to be removed, got %s", state)
+		}
+	})
+
+	t.Run("stops at the limits of the user", func(t *testing.T) {
+		room := testRoom{New()}
+		room.SetCollectionResolver(resolver, 10)
+		room.SetLimits(Limits{MaxQueued: 2})
+		room.UserJoins("A")
+		room.UserQueuesMedium("A", songBySerj)
+		queued, err := room.UserQueuesCollection(context.Background(), "A", playlist)
+		if err != nil || len(queued) != 1 || queued[0].Medium != failCompilation {
+			t.Fatalf("expected only fail compilation to be queued, got %v (%v)", queued, err)
+		}
+		_, err = room.UserQueuesCollection(context.Background(), "A", playlist)
+		if _, ok := err.(*QuotaExceededError); !ok {
+			t.Fatalf("expected quota exceeded error, got %q", err)
+		}
+	})
+
+	t.Run("nothing can be queued", func(t *testing.T) {
+		room := testRoom{New()}
+		room.SetCollectionResolver(resolver, 10)
+		room.UserJoins("A")
+		if _, err := room.UserQueuesCollection(context.Background(), "A", playlist); err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+		if _, err := room.UserQueuesCollection(context.Background(), "A", playlist); err != ErrNothingQueued {
+			t.Fatalf("expected error %q, got %q", ErrNothingQueued, err)
+		}
+	})
+
+	t.Run("resolves the metadata concurrently", func(t *testing.T) {
+		mds := &countingMetadataResolver{delay: 10 * time.Millisecond}
+		room := testRoom{New()}
+		room.SetMetadataResolver(mds)
+		room.SetCollectionResolver(resolver, 10)
+		room.UserJoins(1)
+		room.UserQueuesMedium(1, anotherFailCompilation)
+		mds.calls = 0
+		queued, err := room.UserQueuesCollection(context.Background(), 1, playlist)
+		if err != nil || len(queued) != 2 {
+			t.Fatalf("expected 2 media to be queued, got %v (%v)", queued, err)
+		}
+		// the duplicate is not resolved
+		if mds.calls != 2 {
+			t.Fatalf("expected 2 media to be resolved, got %d", mds.calls)
+		}
+		// neither are blocked media
+		room.SetPolicy(BlockedMedia{failCompilation, anotherFailCompilation, cowsCowsCows})
+		room.UserLeaves(1)
+		room.UserJoins(1)
+		mds.calls = 0
+		if _, err := room.UserQueuesCollection(context.Background(), 1, playlist); err != ErrNothingQueued {
+			t.Fatalf("expected error %q, got %q", ErrNothingQueued, err)
+		}
+		if mds.calls != 0 {
+			t.Fatalf("expected no blocked media to be resolved, got %d", mds.calls)
+		}
+		if mds.maxActive < 2 {
+			t.Fatalf("expected the media to be resolved concurrently, got at most %d at once", mds.maxActive)
+		}
+	})
+
+	t.Run("queues at most max media", func(t *testing.T) {
+		room := New()
+		room.SetCollectionResolver(resolver, 2)
+		room.UserJoins(1)
+		queued, err := room.UserQueuesCollection(context.Background(), 1, playlist)
+		if err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+		if len(queued) != 2 {
+			t.Fatalf("expected 2 media to be queued, got %d", len(queued))
+		}
+	})
+
+	t.Run("unknown collection", func(t *testing.T) {
+		room := New()
+		room.SetCollectionResolver(resolver, 10)
+		room.UserJoins(1)
+		if _, err := room.UserQueuesCollection(context.Background(), 1, &someCollection{"unknown"}); err != medium.ErrNotSupported {
+			t.Fatalf("expected error %q, got %q", medium.ErrNotSupported, err)
+		}
+	})
+}
+
+func TestRoom_Current(t *testing.T) {
+	now := time.Date(2020, 2, 2, 20, 0, 0, 0, time.UTC)
+	room := testRoom{NewWithClock(func() time.Time { return now })}
+	room.UserJoins("A")
+	room.UserJoins("B")
+	states := make(map[medium.Medium]<-chan State)
+	for _, m := range []medium.Medium{nightWitchesBySabaton, wodkaByDaTweekaz, cowsCowsCows, songBySerj} {
+		s, err := room.Room.UserQueuesMedium("A", m)
+		if err != nil {
+			t.Fatalf("did not expect error when adding %q, got %q", m.ID(), err)
+		}
+		states[m] = s
+		now = now.Add(time.Second) // keep the order of equal scores
+	}
+	expectStates := func(m medium.Medium, want ...State) {
+		t.Helper()
+		var got []State
+		for state := range states[m] {
+			got = append(got, state)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("expected %q to reach states %v, got %v", m.ID(), want, got)
+		}
+	}
+
+	if _, ok := room.Current(); ok {
+		t.Fatal("expected nothing to play before anything was dispatched")
+	}
+	if err := room.MediumPlaying(nightWitchesBySabaton); err != ErrMediumUnknown {
+		t.Fatalf("expected error %q when playing a queued medium, got %q", ErrMediumUnknown, err)
+	}
+
+	// dispatched and playing
+	if err := room.MediumDispatched(nightWitchesBySabaton); err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	if err := room.MediumDispatched(nightWitchesBySabaton); err != ErrMediumUnknown {
+		t.Fatalf("expected error %q when dispatching twice, got %q", ErrMediumUnknown, err)
+	}
+	now = now.Add(time.Second)
+	room.MediumPlaying(nightWitchesBySabaton)
+	room.UserVotesMedium("B", nightWitchesBySabaton, +1)
+	current, ok := room.Current()
+	if !ok || current.Medium != nightWitchesBySabaton || current.State != Playing ||
+		!current.StartedAt.Equal(now) || current.Votes["B"] != 1 || current.Score != 1 {
+		t.Fatalf("expected night witches to be playing with a vote of B, got %+v", current)
+	}
+	expectQueue(t, room.Queue(), wodkaByDaTweekaz, cowsCowsCows, songBySerj)
+	if _, err := room.Room.UserQueuesMedium("B", nightWitchesBySabaton); err != ErrMediumAlreadyExists {
+		t.Fatalf("expected error %q when queuing the current medium, got %q", ErrMediumAlreadyExists, err)
+	}
+
+	// the next dispatch finishes the current medium
+	room.MediumDispatched(wodkaByDaTweekaz)
+	expectStates(nightWitchesBySabaton, Dispatched, Playing, Finished)
+	room.MediumSkipped(wodkaByDaTweekaz)
+	expectStates(wodkaByDaTweekaz, Dispatched, Skipped)
+	if _, ok := room.Current(); ok {
+		t.Fatal("expected nothing to play after a skip")
+	}
+	room.MediumDispatched(cowsCowsCows)
+	room.MediumFailed(cowsCowsCows)
+	expectStates(cowsCowsCows, Dispatched, Failed)
+
+	// the current medium keeps playing when its user leaves
+	room.MediumDispatched(songBySerj)
+	room.UserLeaves("A")
+	if current, ok := room.Current(); !ok || current.Medium != songBySerj {
+		t.Fatalf("expected song by serj to keep playing, got %+v", current)
+	}
+	room.MediumPlayed(songBySerj)
+	expectStates(songBySerj, Dispatched, Finished)
+}
+
+func TestRoom_UserVotesSkip(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		threshold string
+		voters    []interface{}
+		skippedBy int // index of the vote that skips, -1 for none
+	}{
+		{desc: "absolute", threshold: "2", voters: []interface{}{"A", "B"}, skippedBy: 1},
+		{desc: "same user twice", threshold: "2", voters: []interface{}{"A", "A"}, skippedBy: -1},
+		{desc: "percentage rounds up", threshold: "50%", voters: []interface{}{"A", "B", "C"}, skippedBy: 2},
+		{desc: "at least one vote", threshold: "1%", voters: []interface{}{"A"}, skippedBy: 0},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			threshold, err := ParseSkipThreshold(tC.threshold)
+			if err != nil {
+				t.Fatalf("did not expect error, got %q", err)
+			}
+			room := testRoom{New()}
+			room.SetSkipThreshold(threshold)
+			for _, user := range []string{"A", "B", "C", "D", "E"} {
+				room.UserJoins(user)
+			}
+			room.UserQueuesMedium("A", nightWitchesBySabaton)
+			room.MediumDispatched(nightWitchesBySabaton)
+			skips, stop := room.WatchSkips()
+			defer stop()
+
+			for i, user := range tC.voters {
+				skipped, err := room.UserVotesSkip(user, nightWitchesBySabaton)
+				if err != nil {
+					t.Fatalf("did not expect error, got %q", err)
+				}
+				if skipped != (i == tC.skippedBy) {
+					t.Fatalf("expected vote %d to skip: %v, got %v", i, i == tC.skippedBy, skipped)
+				}
+				if skipped {
+					break
+				}
+			}
+			if tC.skippedBy < 0 {
+				if current, ok := room.Current(); !ok || current.SkipVotes != 1 {
+					t.Fatalf("expected night witches to play with 1 skip vote, got %+v", current)
+				}
+				return
+			}
+			if _, ok := room.Current(); ok {
+				t.Fatal("expected nothing to play after the skip")
+			}
+			if m := <-skips; m != nightWitchesBySabaton {
+				t.Fatalf("expected players to be told to skip night witches, got %v", m)
+			}
+		})
+	}
+
+	t.Run("percentage of active users", func(t *testing.T) {
+		now := time.Date(2020, 2, 2, 20, 0, 0, 0, time.UTC)
+		room := testRoom{NewWithClock(func() time.Time { return now })}
+		room.SetSkipThreshold(SkipThreshold{Percent: 50, ActiveWithin: time.Hour})
+		for _, user := range []string{"A", "B", "C", "D", "E"} {
+			room.UserJoins(user)
+		}
+		room.UserQueuesMedium("E", cowsCowsCows) // too long ago
+		now = now.Add(2 * time.Hour)
+		room.UserQueuesMedium("A", nightWitchesBySabaton)
+		room.UserVotesMedium("B", nightWitchesBySabaton, +1)
+		room.UserVotesMedium("C", cowsCowsCows, -1)
+		room.MediumDispatched(nightWitchesBySabaton)
+		// A, B and C are active
+		if current, _ := room.Current(); current.SkipsNeeded != 2 {
+			t.Fatalf("expected 2 of 3 active users to be needed, got %d", current.SkipsNeeded)
+		}
+		now = now.Add(30 * time.Minute)
+		if skipped, _ := room.UserVotesSkip("D", nightWitchesBySabaton); skipped {
+			t.Fatal("did not expect the first vote to skip")
+		}
+		// D became active by voting
+		if current, _ := room.Current(); current.SkipsNeeded != 2 {
+			t.Fatalf("expected 2 of 4 active users to be needed, got %d", current.SkipsNeeded)
+		}
+		if skipped, _ := room.UserVotesSkip("A", nightWitchesBySabaton); !skipped {
+			t.Fatal("expected the second vote to skip")
+		}
+	})
+
+	t.Run("not current", func(t *testing.T) {
+		room := testRoom{New()}
+		room.UserJoins("A")
+		room.UserQueuesMedium("A", nightWitchesBySabaton)
+		if _, err := room.UserVotesSkip("A", nightWitchesBySabaton); err != ErrSkippingDisabled {
+			t.Fatalf("expected error %q, got %q", ErrSkippingDisabled, err)
+		}
+		room.SetSkipThreshold(SkipThreshold{Votes: 1})
+		if _, err := room.UserVotesSkip("A", nightWitchesBySabaton); err != ErrMediumUnknown {
+			t.Fatalf("expected error %q, got %q", ErrMediumUnknown, err)
+		}
+	})
+}
+
+func TestParseSkipThreshold(t *testing.T) {
+	testCases := []struct {
+		desc string
+		s    string
+		want SkipThreshold
+		err  bool
+	}{
+		{desc: "votes", s: "3", want: SkipThreshold{Votes: 3}},
+		{desc: "percentage", s: "50%", want: SkipThreshold{Percent: 50}},
+		{desc: "disabled", s: "0", want: SkipThreshold{}},
+		{desc: "too much", s: "150%", err: true},
+		{desc: "negative", s: "-1", err: true},
+		{desc: "garbage", s: "half", err: true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := ParseSkipThreshold(tC.s)
+			if (err != nil) != tC.err {
+				t.Fatalf("expected error: %v, got %v", tC.err, err)
+			}
+			if got != tC.want {
+				t.Errorf("expected %+v, got %+v", tC.want, got)
+			}
+		})
+	}
+}
+
+func TestRoom_SetPolicy(t *testing.T) {
+	loop, short := &someMedium{"loop"}, &otherMedium{"short"}
+	mds := fakeMetadataResolver{
+		loop:  {Title: "Cows Cows Cows (10 Hour Loop)", Artist: "Cow Channel", Duration: 10 * time.Hour},
+		short: {Title: "Night Witches", Artist: "Sabaton", Duration: 3 * time.Minute},
+	}
+	testCases := []struct {
+		desc   string
+		rule   Rule
+		medium medium.Medium
+		reason string
+	}{
+		{desc: "too long", rule: MaxDuration(time.Hour), medium: loop, reason: "longer than 1:00:00"},
+		{desc: "clip short enough", rule: MaxDuration(time.Hour), medium: medium.NewClip(loop, time.Minute, 5*time.Minute)},
+		{desc: "unknown duration", rule: MaxDuration(time.Hour), medium: &someMedium{"unknown"}},
+		{desc: "too short", rule: MinDuration(5 * time.Minute), medium: short, reason: "shorter than 5:00"},
+		{desc: "clip too short", rule: MinDuration(time.Minute), medium: medium.NewClip(loop, time.Minute, 90*time.Second), reason: "shorter than 1:00"},
+		{desc: "long enough", rule: MinDuration(time.Minute), medium: short},
+		{desc: "provider allowed", rule: AllowedProviders{"Other", "youtube"}, medium: short},
+		{desc: "provider not allowed", rule: AllowedProviders{"youtube"}, medium: short, reason: "other is not allowed"},
+		{desc: "blocked medium", rule: BlockedMedia{&otherMedium{"x"}, loop}, medium: medium.NewClip(loop, time.Minute, 0), reason: "it is blocked"},
+		{desc: "blocked artist", rule: BlockedArtists{"cow channel"}, medium: loop, reason: "Cow Channel is blocked"},
+		{desc: "other artist", rule: BlockedArtists{"cow channel"}, medium: short},
+		{desc: "blocked keyword", rule: BlockedKeywords{"mix", "hour loop"}, medium: loop, reason: `the title contains "hour loop"`},
+		{desc: "no keyword", rule: BlockedKeywords{"mix", "hour loop"}, medium: short},
+		{desc: "known duration", rule: KnownDuration{}, medium: short},
+		{desc: "clip of unknown duration", rule: KnownDuration{}, medium: medium.NewClip(&someMedium{"unknown"}, 0, time.Minute)},
+		{desc: "unknown duration rejected", rule: KnownDuration{}, medium: &someMedium{"unknown"}, reason: "its duration is unknown"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			room := New()
+			room.SetMetadataResolver(mds)
+			room.SetPolicy(tC.rule)
+			room.UserJoins("A")
+			_, err := room.UserQueuesMedium("A", tC.medium)
+			if tC.reason == "" {
+				if err != nil {
+					t.Errorf("did not expect error, got %q", err)
+				}
+				return
+			}
+			if err, ok := err.(*PolicyViolationError); !ok || err.Reason != tC.reason {
+				t.Errorf("expected policy violation %q, got %q", tC.reason, err)
+			}
+		})
+	}
+}
+
+func TestRoom_SetPolicy_youTube(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("id") {
+		case "cNtZAbq2Ig4":
+			fmt.Fprint(w, `{"items": [{"snippet": {"title": "Night Witches"}, "contentDetails": {"duration": "PT3M3S"}}]}`)
+		case "YgGzAKP_HuM":
+			fmt.Fprint(w, `{"items": [{"snippet": {"title": "Cows Cows Cows (10 Hour Loop)"}, "contentDetails": {"duration": "PT10H0M1S"}}]}`)
+		default:
+			fmt.Fprint(w, `{"items": []}`)
+		}
+	}))
+	defer server.Close()
+	room := New()
+	room.SetMetadataResolver(&metadata.YouTube{APIKey: "secret", BaseURL: server.URL})
+	room.SetPolicy(MaxDuration(time.Hour), KnownDuration{})
+	room.UserJoins("A")
+
+	testCases := []struct {
+		desc   string
+		rawurl string
+		reason string
+	}{
+		{desc: "short enough", rawurl: "https://www.youtube.com/watch?v=cNtZAbq2Ig4"},
+		{desc: "too long", rawurl: "https://youtu.be/YgGzAKP_HuM", reason: "longer than 1:00:00"},
+		{desc: "clip short enough", rawurl: "https://youtu.be/YgGzAKP_HuM?t=35999"},
+		{desc: "unknown duration", rawurl: "https://youtu.be/dQw4w9WgXcQ", reason: "its duration is unknown"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			m, _ := medium.New(tC.rawurl)
+			_, err := room.UserQueuesMedium("A", m)
+			if tC.reason == "" {
+				if err != nil {
+					t.Errorf("did not expect error, got %q", err)
+				}
+				return
+			}
+			if err, ok := err.(*PolicyViolationError); !ok || err.Reason != tC.reason {
+				t.Errorf("expected policy violation %q, got %q", tC.reason, err)
+			}
+		})
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	video, _ := medium.New("https://youtu.be/cNtZAbq2Ig4")
+	testCases := []struct {
+		desc   string
+		s      string
+		want   Rule
+		format string
+		err    bool
+	}{
+		{desc: "max", s: "max 10:00", want: MaxDuration(10 * time.Minute), format: "max 10m0s"},
+		{desc: "min", s: "MIN 30s", want: MinDuration(30 * time.Second), format: "min 30s"},
+		{desc: "hours", s: "max 1:30:00", want: MaxDuration(90 * time.Minute), format: "max 1h30m0s"},
+		{desc: "providers", s: "providers YouTube  soundcloud", want: AllowedProviders{"youtube", "soundcloud"}, format: "providers youtube soundcloud"},
+		{desc: "block link", s: "block https://www.youtube.com/watch?v=cNtZAbq2Ig4", want: BlockedMedia{video}, format: "block youtube:cNtZAbq2Ig4"},
+		{desc: "block uri", s: "block youtube:cNtZAbq2Ig4", want: BlockedMedia{video}, format: "block youtube:cNtZAbq2Ig4"},
+		{desc: "artist", s: "artist  Earth, Wind & Fire ", want: BlockedArtists{"Earth, Wind & Fire"}, format: "artist Earth, Wind & Fire"},
+		{desc: "keyword", s: "keyword hour loop", want: BlockedKeywords{"hour loop"}, format: "keyword hour loop"},
+		{desc: "known", s: "known", want: KnownDuration{}, format: "known"},
+		{desc: "invalid duration", s: "max long", err: true},
+		{desc: "zero duration", s: "min 0:00", err: true},
+		{desc: "no providers", s: "providers", err: true},
+		{desc: "unsupported link", s: "block https://example.com", err: true},
+		{desc: "no artist", s: "artist", err: true},
+		{desc: "unknown", s: "maximum 10:00", err: true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := ParseRule(tC.s)
+			if (err != nil) != tC.err {
+				t.Fatalf("expected error: %v, got %v", tC.err, err)
+			}
+			if tC.err {
+				return
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tC.want) {
+				t.Errorf("expected %v, got %v", tC.want, got)
+			}
+			if lines, ok := FormatRule(got); !ok || len(lines) != 1 || lines[0] != tC.format {
+				t.Errorf("expected the rule to be formatted as %q, got %q", tC.format, lines)
+			}
+		})
+	}
+}
+
+func TestWithRule(t *testing.T) {
+	rules := []Rule{MaxDuration(time.Hour), BlockedArtists{"Cow Channel"}, KnownDuration{}}
+	rules = WithRule(rules, MaxDuration(10*time.Minute))
+	rules = WithRule(rules, BlockedArtists{"Nickelback"})
+	rules = WithRule(rules, BlockedKeywords{"nightcore"})
+	var got []string
+	for _, rule := range rules {
+		lines, _ := FormatRule(rule)
+		got = append(got, lines...)
+	}
+	want := []string{"known", "max 10m0s", "artist Cow Channel", "artist Nickelback", "keyword nightcore"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRoom_SetLimits(t *testing.T) {
+	t.Run("quota", func(t *testing.T) {
+		room := New()
+		room.SetLimits(Limits{MaxQueued: 2})
+		room.UserJoins("A")
+		room.UserJoins("B")
+		for _, m := range []medium.Medium{songBySerj, failCompilation} {
+			if _, err := room.UserQueuesMedium("A", m); err != nil {
+				t.Fatalf("did not expect error when adding %q, got %q", m.ID(), err)
+			}
+		}
+		_, err := room.UserQueuesMedium("A", cowsCowsCows)
+		if err, ok := err.(*QuotaExceededError); !ok || err.Max != 2 {
+			t.Fatalf("expected quota exceeded error, got %q", err)
+		}
+		if _, err := room.UserQueuesMedium("B", cowsCowsCows); err != nil {
+			t.Fatalf("did not expect error for another user, got %q", err)
+		}
+		room.MediumPlayed(songBySerj)
+		if _, err := room.UserQueuesMedium("A", wodkaByDaTweekaz); err != nil {
+			t.Fatalf("did not expect error after a medium was played, got %q", err)
+		}
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		now := time.Date(2020, 2, 2, 20, 0, 0, 0, time.UTC)
+		room := NewWithClock(func() time.Time { return now })
+		room.SetLimits(Limits{MaxSubmissions: 2, Window: time.Hour})
+		room.UserJoins("A")
+		room.UserJoins("B")
+		queue := func(user string, m medium.Medium) error {
+			_, err := room.UserQueuesMedium(user, m)
+			room.MediumPlayed(m) // played media count as well
+			return err
+		}
+
+		if err := queue("A", songBySerj); err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+		now = now.Add(20 * time.Minute)
+		if err := queue("A", failCompilation); err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+		now = now.Add(20 * time.Minute)
+		err := queue("A", cowsCowsCows)
+		if err, ok := err.(*RateLimitError); !ok || !err.RetryAt.Equal(now.Add(20*time.Minute)) {
+			t.Fatalf("expected rate limit error with retry in 20 minutes, got %q", err)
+		}
+		if err := queue("B", cowsCowsCows); err != nil {
+			t.Fatalf("did not expect error for another user, got %q", err)
+		}
+		if _, ok := queue("A", songBySerj).(*RateLimitError); !ok {
+			t.Fatal("expected rate limit error again") // which does not count
+		}
+		now = now.Add(20 * time.Minute)
+		if err := queue("A", wodkaByDaTweekaz); err != nil {
+			t.Fatalf("did not expect error after the window, got %q", err)
+		}
+		err = queue("A", nightWitchesBySabaton)
+		if err, ok := err.(*RateLimitError); !ok || !err.RetryAt.Equal(now.Add(20*time.Minute)) {
+			t.Fatalf("expected rate limit error with retry in 20 minutes, got %q", err)
+		}
+	})
+}
+
+func TestRoom_SetAging(t *testing.T) {
+	now := time.Date(2020, 2, 2, 20, 0, 0, 0, time.UTC)
+	room := testRoom{NewWithClock(func() time.Time { return now })}
+	room.SetAging(Aging{HalfLife: time.Hour, PointsPerHour: 0.1})
+	room.UserJoins("A")
+	room.UserJoins("B")
+
+	// at 8pm
+	room.UserQueuesMedium("A", nightWitchesBySabaton)
+	room.UserVotesMedium("B", nightWitchesBySabaton, -1)
+	room.UserQueuesMedium("A", wodkaByDaTweekaz)
+	room.UserVotesMedium("B", wodkaByDaTweekaz, +1)
+	if score, effective, _ := room.GetMediumScore(wodkaByDaTweekaz); score != 1 || effective != 1 {
+		t.Fatalf("expected fresh vote to count fully, got %d and %f", score, effective)
+	}
+
+	// at 3am
+	now = now.Add(7 * time.Hour)
+	room.UserQueuesMedium("B", cowsCowsCows)
+	room.UserQueuesMedium("B", songBySerj)
+	room.UserVotesMedium("A", songBySerj, +1)
+	expectQueue(t, room.Queue(), songBySerj, wodkaByDaTweekaz, nightWitchesBySabaton, cowsCowsCows)
+	score, effective, _ := room.GetMediumScore(nightWitchesBySabaton)
+	if want := 0.7 - 1.0/128; score != -1 || math.Abs(effective-want) > 1e-9 {
+		t.Errorf("expected score -1 and effective score %f, got %d and %f", want, score, effective)
+	}
+	for _, item := range room.Queue() {
+		if _, effective, _ := room.GetMediumScore(item.Medium); item.EffectiveScore != effective {
+			t.Errorf("expected queue to have effective score %f, got %f", effective, item.EffectiveScore)
+		}
+	}
+
+	// without aging
+	room.SetAging(Aging{})
+	expectQueue(t, room.Queue(), wodkaByDaTweekaz, songBySerj, cowsCowsCows, nightWitchesBySabaton)
+}
+
+func TestRoom_History(t *testing.T) {
+	now := time.Date(2020, 2, 2, 20, 0, 0, 0, time.UTC)
+	room := testRoom{NewWithClock(func() time.Time { return now })}
+	room.SetRepostCooldown(time.Hour)
+	room.SetDetectProbableReposts(true)
+	nightWitchesElsewhere := &otherMedium{"night witches"}
+	room.SetMetadataResolver(fakeMetadataResolver{
+		nightWitchesBySabaton: {Title: "Night Witches", Artist: "Sabaton"},
+		nightWitchesElsewhere: {Title: "Sabaton - Night Witches (Official Video)"},
+	})
+	room.UserJoins("A")
+	room.UserJoins("B")
+
+	room.UserQueuesMedium("A", nightWitchesBySabaton)
+	room.UserQueuesMedium("A", cowsCowsCows)
+	room.UserQueuesMedium("B", wodkaByDaTweekaz)
+	room.UserQueuesMedium("B", songBySerj)
+	room.UserVotesMedium("B", nightWitchesBySabaton, +1)
+	room.MediumDispatched(nightWitchesBySabaton)
+	now = now.Add(3 * time.Minute)
+	room.MediumDispatched(cowsCowsCows)
+	room.MediumFailed(cowsCowsCows)
+	room.MediumSkipped(songBySerj) // while queued
+	room.MediumDispatched(wodkaByDaTweekaz)
+	room.MediumSkipped(wodkaByDaTweekaz)
+
+	history := room.History()
+	if len(history) != 2 {
+		t.Fatalf("expected 2 plays, got %+v", history)
+	}
+	if play := history[0]; play.Medium != wodkaByDaTweekaz || play.State != Skipped || play.User != "B" {
+		t.Errorf("expected wodka to be skipped last, got %+v", play)
+	}
+	if play := history[1]; play.Medium != nightWitchesBySabaton || play.State != Finished ||
+		play.User != "A" || play.Score != 1 || !play.EndedAt.Equal(now) || play.Metadata.Title != "Night Witches" {
+		t.Errorf("expected night witches to be played first, got %+v", play)
+	}
+
+	// reposts within the cooldown
+	now = now.Add(30 * time.Minute)
+	_, err := room.Room.UserQueuesMedium("B", &someMedium{"night witches by sabaton"})
+	if err, ok := err.(*RecentlyPlayedError); !ok || err.Play.Medium != nightWitchesBySabaton {
+		t.Fatalf("expected night witches to be played recently, got %v", err)
+	}
+	_, err = room.Room.UserQueuesMedium("B", nightWitchesElsewhere)
+	if err, ok := err.(*ProbableRepostError); !ok || err.Original != nightWitchesBySabaton {
+		t.Fatalf("expected probable repost of night witches, got %v", err)
+	}
+	if _, err := room.Room.UserQueuesMedium("B", cowsCowsCows); err != nil {
+		t.Fatalf("expected failed media to be queued again, got %q", err)
+	}
+	if _, err := room.Room.UserQueuesMedium("B", songBySerj); err != nil {
+		t.Fatalf("expected media skipped while queued to be queued again, got %q", err)
+	}
+
+	// after the cooldown
+	now = now.Add(30 * time.Minute)
+	if _, err := room.Room.UserQueuesMedium("B", nightWitchesBySabaton); err != nil {
+		t.Fatalf("did not expect error after the cooldown, got %q", err)
+	}
+}
+
+func TestRoom_Restore(t *testing.T) {
+	now := time.Date(2020, 2, 2, 20, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	room := testRoom{NewWithClock(clock)}
+	room.SetMetadataResolver(fakeMetadataResolver{
+		songBySerj: {Title: "Chop Suey!", Artist: "System Of A Down"},
+	})
+	room.SetOrdering(RoundRobin{})
+	room.SetSkipThreshold(SkipThreshold{Votes: 2})
+	room.UserJoins("A")
+	room.UserJoins("B")
+	room.UserQueuesMedium("A", nightWitchesBySabaton)
+	room.UserQueuesMedium("A", songBySerj)
+	room.UserQueuesMedium("A", cowsCowsCows)
+	room.UserQueuesMedium("B", wodkaByDaTweekaz)
+	room.UserVotesMedium("B", songBySerj, +1)
+	room.UserVotesMedium("A", cowsCowsCows, -1)
+	room.MediumDispatched(nightWitchesBySabaton)
+	room.MediumPlayed(nightWitchesBySabaton)
+	now = now.Add(time.Minute)
+	room.MediumDispatched(wodkaByDaTweekaz)
+	room.MediumPlaying(wodkaByDaTweekaz)
+	room.UserVotesSkip("A", wodkaByDaTweekaz)
+
+	restored := testRoom{NewWithClock(clock)}
+	restored.SetOrdering(RoundRobin{})
+	restored.SetSkipThreshold(SkipThreshold{Votes: 2})
+	queued := restored.Restore(room.Snapshot())
+	if len(queued) != 3 {
+		t.Fatalf("expected 3 restored media, got %d", len(queued))
+	}
+	expectQueue(t, restored.Queue(), songBySerj, cowsCowsCows)
+	if score, _, _ := restored.GetMediumScore(songBySerj); score != 1 {
+		t.Errorf("expected score 1 of song by serj, got %d", score)
+	}
+	if md, _ := restored.GetMediumMetadata(songBySerj); md.Title != "Chop Suey!" {
+		t.Errorf("expected metadata of song by serj, got %+v", md)
+	}
+	current, ok := restored.Current()
+	if !ok || current.Medium != wodkaByDaTweekaz || current.State != Playing || current.SkipVotes != 1 {
+		t.Fatalf("expected wodka to be playing with a skip vote, got %+v", current)
+	}
+	if history := restored.History(); len(history) != 1 || history[0].Medium != nightWitchesBySabaton {
+		t.Errorf("expected night witches to be played, got %+v", history)
+	}
+
+	// the restored media keep working
+	if skipped, err := restored.UserVotesSkip("B", wodkaByDaTweekaz); !skipped || err != nil {
+		t.Fatalf("expected wodka to be skipped, got %v and %v", skipped, err)
+	}
+	for _, q := range queued {
+		if q.Medium == wodkaByDaTweekaz {
+			if state := <-q.States; state != Skipped {
+				t.Errorf("expected wodka to be skipped, got %s", state)
+			}
+		}
+	}
+	restored.MediumDispatched(songBySerj)
+	expectQueue(t, restored.Queue(), cowsCowsCows)
+}
+
+func TestRoom_GetMediumMetadata(t *testing.T) {
+	room := testRoom{New()}
+	room.SetMetadataResolver(fakeMetadataResolver{
+		nightWitchesBySabaton: {Title: "Night Witches", Artist: "Sabaton"},
+	})
+	room.UserJoins("A")
+	room.UserQueuesMedium("A", nightWitchesBySabaton)
+	room.UserQueuesMedium("A", cowsCowsCows)
+
+	if md, ok := room.GetMediumMetadata(nightWitchesBySabaton); !ok || md.Title != "Night Witches" {
+		t.Errorf("expected metadata of night witches, got %#v", md)
+	}
+	if md, ok := room.GetMediumMetadata(cowsCowsCows); !ok || md != (metadata.Metadata{}) {
+		t.Errorf("expected empty metadata if it cannot be resolved, got %#v", md)
+	}
+	if _, ok := room.GetMediumMetadata(wodkaByDaTweekaz); ok {
+		t.Error("expected unknown medium to have no metadata")
+	}
+}
+
+func expectQueue(t *testing.T, q []QueueItem, want ...medium.Medium) {
+	t.Helper()
+	var got, wanted []interface{}
+	for _, item := range q {
+		got = append(got, item.Medium.ID())
+	}
+	for _, m := range want {
+		wanted = append(wanted, m.ID())
+	}
+	if fmt.Sprint(got) != fmt.Sprint(wanted) {
+		t.Fatalf("expected queue %v, got %v", wanted, got)
+	}
 }
 
 type someProvider struct{}
@@ -122,3 +1047,84 @@ func (m *someMedium) Provider() medium.Provider {
 func (m *someMedium) ID() interface{} {
 	return m.string
 }
+
+type otherProvider struct{}
+
+func (p otherProvider) String() string {
+	return "other"
+}
+
+type otherMedium struct {
+	string
+}
+
+func (m *otherMedium) Provider() medium.Provider {
+	return otherProvider{}
+}
+
+func (m *otherMedium) ID() interface{} {
+	return m.string
+}
+
+type someCollection struct {
+	string
+}
+
+func (c *someCollection) Provider() medium.Provider {
+	return someProvider{}
+}
+
+func (c *someCollection) ID() interface{} {
+	return c.string
+}
+
+func (c *someCollection) IsCollection() bool {
+	return true
+}
+
+type fakeResolver map[medium.Collection][]medium.Medium
+
+func (r fakeResolver) Resolve(_ context.Context, c medium.Collection, max int) ([]medium.Medium, error) {
+	media, ok := r[c]
+	if !ok {
+		return nil, medium.ErrNotSupported
+	}
+	if len(media) > max {
+		media = media[:max]
+	}
+	return media, nil
+}
+
+// countingMetadataResolver counts the calls and how many of them run at once.
+type countingMetadataResolver struct {
+	delay     time.Duration
+	l         sync.Mutex
+	calls     int
+	active    int
+	maxActive int
+}
+
+func (r *countingMetadataResolver) Resolve(_ context.Context, m medium.Medium) (metadata.Metadata, error) {
+	r.l.Lock()
+	r.calls++
+	r.active++
+	if r.active > r.maxActive {
+		r.maxActive = r.active
+	}
+	r.l.Unlock()
+	time.Sleep(r.delay)
+	r.l.Lock()
+	r.active--
+	r.l.Unlock()
+	return metadata.Metadata{Title: fmt.Sprint(m.ID())}, nil
+}
+
+type fakeMetadataResolver map[medium.Medium]metadata.Metadata
+
+func (r fakeMetadataResolver) Resolve(_ context.Context, m medium.Medium) (metadata.Metadata, error) {
+	md, ok := r[m]
+	if !ok {
+		return metadata.Metadata{}, medium.ErrNotSupported
+	}
+	return md, nil
+}
@@ -0,0 +1,156 @@
+package room
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+)
+
+// Snapshot is the state of a room that is kept across restarts. Users are the
+// values that were passed to the room. The configuration of the room, like
+// its policy, is not part of it.
+type Snapshot struct {
+	Users []UserSnapshot
+	// Media are the queued media and the current one.
+	Media []MediumSnapshot
+	// History are the played media, oldest first.
+	History []Play
+	// LastPlayedUser is the user whose media were played last and Streak how
+	// many in a row.
+	LastPlayedUser interface{}
+	Streak         int
+}
+
+// UserSnapshot is the state of a user in a room.
+type UserSnapshot struct {
+	User         interface{}
+	LastPlayedAt time.Time
+	// LastActiveAt is when the user queued or voted last.
+	LastActiveAt time.Time
+	// Submissions are when the user queued media within the window of the
+	// rate limit, oldest first.
+	Submissions []time.Time
+}
+
+// MediumSnapshot is the state of a queued or current medium.
+type MediumSnapshot struct {
+	Medium   medium.Medium
+	User     interface{}
+	Metadata metadata.Metadata
+	AddedAt  time.Time
+	Votes    map[interface{}]int
+	VotedAt  map[interface{}]time.Time
+	// SkipVotes are the users that voted to skip the current medium.
+	SkipVotes []interface{}
+	// State is Queued for queued media and Dispatched or Playing for the
+	// current one.
+	State        State
+	DispatchedAt time.Time
+	StartedAt    time.Time
+}
+
+// Snapshot returns the state of the room.
+func (r *Room) Snapshot() Snapshot {
+	r.l.RLock()
+	defer r.l.RUnlock()
+	s := Snapshot{
+		Users:          make([]UserSnapshot, 0, len(r.users)),
+		Media:          make([]MediumSnapshot, 0, len(r.media)),
+		History:        append([]Play(nil), r.history...),
+		LastPlayedUser: r.lastPlayedUser,
+		Streak:         r.streak,
+	}
+	for user, info := range r.users {
+		s.Users = append(s.Users, UserSnapshot{
+			User:         user,
+			LastPlayedAt: info.lastPlayedAt,
+			LastActiveAt: info.lastActiveAt,
+			Submissions:  append([]time.Time(nil), info.submissions...),
+		})
+	}
+	for m, info := range r.media {
+		ms := MediumSnapshot{
+			Medium:       m,
+			User:         info.user,
+			Metadata:     info.metadata,
+			AddedAt:      info.addedAt,
+			Votes:        make(map[interface{}]int, len(info.votes)),
+			VotedAt:      make(map[interface{}]time.Time, len(info.votedAt)),
+			State:        info.state,
+			DispatchedAt: info.dispatchedAt,
+			StartedAt:    info.startedAt,
+		}
+		for user, gravity := range info.votes {
+			ms.Votes[user] = gravity
+		}
+		for user, at := range info.votedAt {
+			ms.VotedAt[user] = at
+		}
+		for user := range info.skipVotes {
+			ms.SkipVotes = append(ms.SkipVotes, user)
+		}
+		s.Media = append(s.Media, ms)
+	}
+	sort.SliceStable(s.Media, func(i, j int) bool {
+		return s.Media[i].AddedAt.Before(s.Media[j].AddedAt)
+	})
+	return s
+}
+
+// Restore replaces the users, media and history of the room with the
+// snapshot. It must be called before the room is used. It returns the
+// restored media with the channels that receive their states from now on.
+func (r *Room) Restore(s Snapshot) []QueuedMedium {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.users = make(map[interface{}]*userInfo, len(s.Users))
+	for _, u := range s.Users {
+		r.users[u.User] = &userInfo{
+			lastPlayedAt: u.LastPlayedAt,
+			lastActiveAt: u.LastActiveAt,
+			submissions:  append([]time.Time(nil), u.Submissions...),
+		}
+	}
+	r.media = make(map[medium.Medium]*mediumInfo, len(s.Media))
+	r.current = nil
+	restored := make([]QueuedMedium, 0, len(s.Media))
+	for _, ms := range s.Media {
+		info := &mediumInfo{
+			user:         ms.User,
+			metadata:     ms.Metadata,
+			addedAt:      ms.AddedAt,
+			votes:        make(map[interface{}]int, len(ms.Votes)),
+			votedAt:      make(map[interface{}]time.Time, len(ms.VotedAt)),
+			skipVotes:    make(map[interface{}]bool, len(ms.SkipVotes)),
+			state:        ms.State,
+			dispatchedAt: ms.DispatchedAt,
+			startedAt:    ms.StartedAt,
+			states:       make(chan State, 3), // dispatched, playing and terminal
+		}
+		for user, gravity := range ms.Votes {
+			info.votes[user] = gravity
+			info.score += gravity
+		}
+		for user, at := range ms.VotedAt {
+			info.votedAt[user] = at
+		}
+		for _, user := range ms.SkipVotes {
+			info.skipVotes[user] = true
+		}
+		if ms.State == Dispatched || ms.State == Playing {
+			if r.current != nil {
+				continue // there can only be one
+			}
+			r.current = ms.Medium
+		} else if ms.State != Queued {
+			continue
+		}
+		r.media[ms.Medium] = info
+		restored = append(restored, QueuedMedium{ms.Medium, info.states})
+	}
+	r.history = append([]Play(nil), s.History...)
+	r.lastPlayedUser, r.streak = s.LastPlayedUser, s.Streak
+	return restored
+}
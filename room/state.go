@@ -0,0 +1,127 @@
+package room
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// State is the state of a medium in a room. Media are queued, then
+// dispatched to the player, then playing and finally finished, skipped or
+// failed. Queued media are removed if the user that queued them leaves.
+type State int
+
+// states
+const (
+	Queued State = iota
+	Dispatched
+	Playing
+	Finished
+	Skipped
+	Failed
+	Removed
+)
+
+// Terminal returns whether the medium left the room in the state.
+func (s State) Terminal() bool {
+	return s >= Finished
+}
+
+func (s State) String() string {
+	switch s {
+	case Queued:
+		return "queued"
+	case Dispatched:
+		return "dispatched"
+	case Playing:
+		return "playing"
+	case Finished:
+		return "finished"
+	case Skipped:
+		return "skipped"
+	case Failed:
+		return "failed"
+	case Removed:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// NowPlaying is the medium that was dispatched to the player last and did
+// not end, yet.
+type NowPlaying struct {
+	QueueItem
+	State        State
+	DispatchedAt time.Time
+	// StartedAt is when the player started playing the medium. It is zero if
+	// the player did not report it, yet.
+	StartedAt time.Time
+	// Votes are the votes on the medium by user.
+	Votes map[interface{}]int
+	// SkipVotes is how many users voted to skip the medium and SkipsNeeded
+	// how many votes skip it. SkipsNeeded is 0 if skipping is disabled.
+	SkipVotes   int
+	SkipsNeeded int
+}
+
+// SkipThreshold is how many votes are needed to skip the current medium,
+// either as absolute number of votes or as percentage of the active users in
+// the room. The percentage is used if it is set. The zero value disables
+// skipping.
+type SkipThreshold struct {
+	Votes   int
+	Percent float64
+	// ActiveWithin is how recently users must have queued or voted to count
+	// as active. All users of the room are active if it is 0.
+	ActiveWithin time.Duration
+}
+
+// ParseSkipThreshold parses thresholds like "3" for 3 votes or "50%" for half
+// of the users.
+func ParseSkipThreshold(s string) (SkipThreshold, error) {
+	s = strings.TrimSpace(s)
+	if p := strings.TrimSuffix(s, "%"); p != s {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || percent < 0 || percent > 100 {
+			return SkipThreshold{}, errors.New("invalid skip percentage: " + s)
+		}
+		return SkipThreshold{Percent: percent}, nil
+	}
+	votes, err := strconv.Atoi(s)
+	if err != nil || votes < 0 {
+		return SkipThreshold{}, errors.New("invalid skip votes: " + s)
+	}
+	return SkipThreshold{Votes: votes}, nil
+}
+
+func (t SkipThreshold) enabled() bool {
+	return t.Votes > 0 || t.Percent > 0
+}
+
+// needed returns how many votes skip a medium in a room with the number of
+// active users, but at least 1. It is 0 if skipping is disabled.
+func (t SkipThreshold) needed(users int) int {
+	switch {
+	case t.Percent > 0:
+		n := int(math.Ceil(t.Percent / 100 * float64(users)))
+		if n < 1 {
+			n = 1
+		}
+		return n
+	case t.Votes > 0:
+		return t.Votes
+	}
+	return 0
+}
+
+func (t SkipThreshold) String() string {
+	switch {
+	case t.Percent > 0:
+		return strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"
+	case t.Votes > 0:
+		return strconv.Itoa(t.Votes)
+	}
+	return "disabled"
+}
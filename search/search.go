@@ -0,0 +1,162 @@
+// Package search finds media by text.
+package search
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+)
+
+// errors
+var (
+	ErrSearchUnknown = errors.New("search unknown")
+	ErrNotYourSearch = errors.New("search belongs to another user")
+	ErrResultUnknown = errors.New("result unknown")
+)
+
+// Result is a medium that was found.
+type Result struct {
+	Medium   medium.Medium
+	Metadata metadata.Metadata
+}
+
+// Backend searches media by text.
+type Backend interface {
+	// Search returns at most limit results for the query, best first.
+	Search(ctx context.Context, query string, limit int) ([]Result, error)
+}
+
+// Static is a backend that searches a fixed list of media by their title and
+// artist. A result matches if it contains all words of the query, ignoring
+// punctuation like the dash in "artist - title".
+type Static []Result
+
+// Search returns the results that match the query in order.
+func (s Static) Search(_ context.Context, query string, limit int) ([]Result, error) {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var results []Result
+	for _, r := range s {
+		if len(results) >= limit {
+			break
+		}
+		text := strings.ToLower(r.Metadata.Artist + " " + r.Metadata.Title)
+		match := len(words) > 0
+		for _, w := range words {
+			match = match && strings.Contains(text, w)
+		}
+		if match {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// Searches keeps the results of searches until the user that searched picks
+// one of them.
+type Searches struct {
+	backend Backend
+	limit   int
+	ttl     time.Duration
+
+	l       sync.Mutex
+	pending map[string]*pendingSearch
+}
+
+type pendingSearch struct {
+	user    interface{}
+	results []Result
+	created time.Time
+}
+
+// NewSearches returns searches that show at most limit results and are
+// forgotten after the ttl if nothing is picked.
+func NewSearches(backend Backend, limit int, ttl time.Duration) *Searches {
+	return &Searches{
+		backend: backend,
+		limit:   limit,
+		ttl:     ttl,
+		pending: make(map[string]*pendingSearch),
+	}
+}
+
+// TTL returns how long searches are kept if nothing is picked.
+func (s *Searches) TTL() time.Duration {
+	return s.ttl
+}
+
+// Search searches media for the user. It returns the results and a token to
+// pick one of them. No token is returned if nothing was found.
+func (s *Searches) Search(ctx context.Context, user interface{}, query string) (string, []Result, error) {
+	results, err := s.backend.Search(ctx, query, s.limit)
+	if err != nil || len(results) == 0 {
+		return "", nil, err
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	now := time.Now()
+	for token, p := range s.pending {
+		if now.Sub(p.created) > s.ttl {
+			delete(s.pending, token)
+		}
+	}
+	token := randomToken()
+	s.pending[token] = &pendingSearch{user, results, now}
+	return token, results, nil
+}
+
+// Pick returns the result at the index of the search with the token and
+// forgets the search. Only the user that searched can pick a result.
+func (s *Searches) Pick(token string, user interface{}, index int) (Result, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	p, ok := s.pending[token]
+	if !ok || time.Since(p.created) > s.ttl {
+		return Result{}, ErrSearchUnknown
+	}
+	if p.user != user {
+		return Result{}, ErrNotYourSearch
+	}
+	if index < 0 || index >= len(p.results) {
+		return Result{}, ErrResultUnknown
+	}
+	delete(s.pending, token)
+	return p.results[index], nil
+}
+
+// Cancel forgets the search with the token. Only the user that searched can
+// cancel it.
+func (s *Searches) Cancel(token string, user interface{}) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	p, ok := s.pending[token]
+	if !ok || time.Since(p.created) > s.ttl {
+		return ErrSearchUnknown
+	}
+	if p.user != user {
+		return ErrNotYourSearch
+	}
+	delete(s.pending, token)
+	return nil
+}
+
+// randomToken returns a random token, so that the buttons of searches from an
+// earlier run cannot pick results of a new one.
+func randomToken() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return strconv.FormatUint(binary.LittleEndian.Uint64(b[:]), 36)
+}
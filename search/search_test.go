@@ -0,0 +1,161 @@
+package search_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+	"github.com/Teelevision/telegram-duebelwein-bot/room"
+	. "github.com/Teelevision/telegram-duebelwein-bot/search"
+)
+
+var (
+	nightWitches  = result("cNtZAbq2Ig4", "Sabaton", "Night Witches")
+	primoVictoria = result("YgGzAKP_HuM", "Sabaton", "Primo Victoria")
+	wodka         = result("jZya02M_caU", "Da Tweekaz", "Wodka")
+	fake          = Static{nightWitches, primoVictoria, wodka}
+)
+
+func TestStatic(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		query   string
+		limit   int
+		results []Result
+	}{
+		{desc: "artist", query: "sabaton", limit: 5, results: []Result{nightWitches, primoVictoria}},
+		{desc: "artist and title", query: "Sabaton - Night", limit: 5, results: []Result{nightWitches}},
+		{desc: "limit", query: "sabaton", limit: 1, results: []Result{nightWitches}},
+		{desc: "nothing", query: "cows", limit: 5, results: nil},
+		{desc: "empty", query: " ", limit: 5, results: nil},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			results, err := fake.Search(context.Background(), tC.query, tC.limit)
+			if err != nil {
+				t.Fatalf("did not expect error, got %q", err)
+			}
+			if len(results) != len(tC.results) {
+				t.Fatalf("expected %d results, got %d", len(tC.results), len(results))
+			}
+			for i := range results {
+				if results[i] != tC.results[i] {
+					t.Errorf("expected result #%d to be %v, got %v", i, tC.results[i], results[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSearches(t *testing.T) {
+	searches := NewSearches(fake, 5, time.Hour)
+	r := room.New()
+	r.UserJoins("Marius")
+	r.UserJoins("Max")
+
+	t.Run("search, pick and queue", func(t *testing.T) {
+		token, results, err := searches.Search(context.Background(), "Marius", "sabaton")
+		if err != nil || len(results) != 2 || token == "" {
+			t.Fatalf("expected 2 results and a token, got %v, %q (%v)", results, token, err)
+		}
+		if _, err := searches.Pick(token, "Max", 1); err != ErrNotYourSearch {
+			t.Errorf("expected error %q, got %q", ErrNotYourSearch, err)
+		}
+		if _, err := searches.Pick(token, "Marius", 2); err != ErrResultUnknown {
+			t.Errorf("expected error %q, got %q", ErrResultUnknown, err)
+		}
+		picked, err := searches.Pick(token, "Marius", 1)
+		if err != nil || picked != primoVictoria {
+			t.Fatalf("expected primo victoria, got %v (%v)", picked, err)
+		}
+		if _, err := searches.Pick(token, "Marius", 0); err != ErrSearchUnknown {
+			t.Errorf("expected search to be forgotten, got %q", err)
+		}
+		if _, err := r.UserQueuesMedium("Marius", picked.Medium); err != nil {
+			t.Fatalf("did not expect error when queuing, got %q", err)
+		}
+		if q := r.Queue(); len(q) != 1 || !medium.Identical(q[0].Medium, primoVictoria.Medium) {
+			t.Errorf("expected primo victoria to be queued, got %v", q)
+		}
+	})
+
+	t.Run("nothing found", func(t *testing.T) {
+		token, results, err := searches.Search(context.Background(), "Marius", "cows")
+		if err != nil || len(results) != 0 || token != "" {
+			t.Errorf("expected no results and no token, got %v, %q (%v)", results, token, err)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		token, _, _ := searches.Search(context.Background(), "Max", "wodka")
+		if err := searches.Cancel(token, "Marius"); err != ErrNotYourSearch {
+			t.Errorf("expected error %q, got %q", ErrNotYourSearch, err)
+		}
+		if err := searches.Cancel(token, "Max"); err != nil {
+			t.Errorf("did not expect error, got %q", err)
+		}
+		if _, err := searches.Pick(token, "Max", 0); err != ErrSearchUnknown {
+			t.Errorf("expected search to be forgotten, got %q", err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		searches := NewSearches(fake, 5, 0)
+		token, _, _ := searches.Search(context.Background(), "Max", "wodka")
+		searches.Search(context.Background(), "Marius", "sabaton") // forgets expired searches
+		if _, err := searches.Pick(token, "Max", 0); err != ErrSearchUnknown {
+			t.Errorf("expected expired search to be forgotten, got %q", err)
+		}
+		// even if no other search forgot it
+		token, _, _ = searches.Search(context.Background(), "Max", "wodka")
+		if err := searches.Cancel(token, "Max"); err != ErrSearchUnknown {
+			t.Errorf("expected expired search to be unknown, got %q", err)
+		}
+	})
+}
+
+func TestYouTube(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/search" || q.Get("key") != "secret" || q.Get("q") != "sabaton night" || q.Get("maxResults") != "2" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"items": [
+			{"id": {"videoId": "cNtZAbq2Ig4"}, "snippet": {"title": "Night Witches &amp; more", "channelTitle": "Sabaton", "thumbnails": {"default": {"url": "https://i.ytimg.com/vi/cNtZAbq2Ig4/default.jpg"}}}},
+			{"id": {"channelId": "UCxxxxxxxxxxxxxxxxxxxxxx"}, "snippet": {"title": "Sabaton"}}
+		]}`)
+	}))
+	defer server.Close()
+
+	backend := &YouTube{APIKey: "secret", BaseURL: server.URL}
+	results, err := backend.Search(context.Background(), "sabaton night", 2)
+	if err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	want := Result{nightWitches.Medium, metadata.Metadata{
+		Title:     "Night Witches & more",
+		Artist:    "Sabaton",
+		Thumbnail: "https://i.ytimg.com/vi/cNtZAbq2Ig4/default.jpg",
+	}}
+	if len(results) != 1 || results[0] != want {
+		t.Errorf("expected %v, got %v", want, results)
+	}
+
+	if _, err := backend.Search(context.Background(), "other", 2); err == nil {
+		t.Error("expected error if the api fails")
+	}
+}
+
+func result(videoID, artist, title string) Result {
+	m, err := medium.NewYouTubeVideo(videoID)
+	if err != nil {
+		panic(err)
+	}
+	return Result{m, metadata.Metadata{Title: title, Artist: artist}}
+}
@@ -0,0 +1,89 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+)
+
+// YouTube searches YouTube videos using the YouTube Data API.
+type YouTube struct {
+	// APIKey is the key used to access the api.
+	APIKey string
+	// BaseURL is the base url of the api. It defaults to
+	// medium.YouTubeAPIBaseURL.
+	BaseURL string
+	// Client is used for the requests. It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Search returns at most limit videos for the query.
+func (y *YouTube) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	baseURL, client := y.BaseURL, y.Client
+	if baseURL == "" {
+		baseURL = medium.YouTubeAPIBaseURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	params := url.Values{
+		"part":       {"snippet"},
+		"type":       {"video"},
+		"q":          {query},
+		"maxResults": {strconv.Itoa(limit)},
+		"key":        {y.APIKey},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("youtube api responded with %s", resp.Status)
+	}
+
+	var body struct {
+		Items []struct {
+			ID struct {
+				VideoID string `json:"videoId"`
+			} `json:"id"`
+			Snippet struct {
+				Title        string `json:"title"`
+				ChannelTitle string `json:"channelTitle"`
+				Thumbnails   struct {
+					Default struct {
+						URL string `json:"url"`
+					} `json:"default"`
+				} `json:"thumbnails"`
+			} `json:"snippet"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	var results []Result
+	for _, item := range body.Items {
+		m, err := medium.NewYouTubeVideo(item.ID.VideoID)
+		if err != nil {
+			continue
+		}
+		// the api returns html escaped titles
+		results = append(results, Result{m, metadata.Metadata{
+			Title:     html.UnescapeString(item.Snippet.Title),
+			Artist:    html.UnescapeString(item.Snippet.ChannelTitle),
+			Thumbnail: item.Snippet.Thumbnails.Default.URL,
+		}})
+	}
+	return results, nil
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	journalFile  = "journal.jsonl"
+	// minCompactSize is the size in bytes the journal must reach before it
+	// is merged into the snapshot. Larger snapshots are compacted once the
+	// journal outgrows them.
+	minCompactSize = 1 << 20
+)
+
+// File stores chats in a directory. Every saved chat is appended to a journal
+// and synced to disk before Save returns. The journal is merged into a
+// snapshot once it outgrows the snapshot, as well as when the storage is
+// opened or closed.
+type File struct {
+	l            sync.Mutex
+	dir          string
+	chats        map[int64]Chat
+	seq          uint64 // of the last journal entry
+	journal      *os.File
+	journalSize  int64 // in bytes
+	snapshotSize int64 // in bytes
+	// torn is whether the journal may end with a partial entry, which must
+	// be removed before more entries are appended
+	torn bool
+}
+
+// snapshot is the content of the snapshot file. Seq is the sequence number
+// of the last journal entry that is part of it.
+type snapshot struct {
+	Seq   uint64
+	Chats []Chat
+}
+
+// entry is a line of the journal.
+type entry struct {
+	Seq  uint64
+	Chat Chat
+}
+
+// OpenFile opens the storage in the directory, which is created if it does
+// not exist. Media and plays that cannot be decoded anymore, e.g. because
+// their provider was removed, are logged and dropped.
+func OpenFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &File{dir: dir, chats: make(map[int64]Chat)}
+	if err := s.read(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// read reads the snapshot and applies the journal entries that are newer.
+func (s *File) read() error {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, snapshotFile))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		var snap snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return fmt.Errorf("could not read snapshot: %w", err)
+		}
+		s.seq = snap.Seq
+		for _, chat := range snap.Chats {
+			s.chats[chat.ID] = decoded(chat)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, journalFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			// entries might be cut off by a crash or a failed write
+			var e entry
+			if err := json.Unmarshal(line, &e); err != nil {
+				log.Printf("skipped corrupt line %d of the journal: %s", n, err)
+			} else if e.Seq > s.seq { // not part of the snapshot, yet
+				s.seq = e.Seq
+				s.chats[e.Chat.ID] = decoded(e.Chat)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("could not read journal: %w", err)
+		}
+	}
+}
+
+// decoded returns the chat without the media and plays that could not be
+// decoded.
+func decoded(chat Chat) Chat {
+	media := chat.Media[:0]
+	for _, m := range chat.Media {
+		if m.Medium.Medium != nil {
+			media = append(media, m)
+		}
+	}
+	chat.Media = media
+	history := chat.History[:0]
+	for _, play := range chat.History {
+		if play.Medium.Medium != nil {
+			history = append(history, play)
+		}
+	}
+	chat.History = history
+	return chat
+}
+
+// compact writes the chats to the snapshot and starts a new journal. The
+// caller must hold the lock, if there is one.
+func (s *File) compact() error {
+	data, err := json.Marshal(snapshot{Seq: s.seq, Chats: sortedChats(s.chats)})
+	if err != nil {
+		return err
+	}
+	// replace the snapshot atomically
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err := writeFile(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	s.snapshotSize = int64(len(data))
+
+	if s.journal != nil {
+		s.journal.Close()
+	}
+	s.journal, err = os.OpenFile(filepath.Join(s.dir, journalFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	s.journalSize = 0
+	s.torn = false
+	return nil
+}
+
+// writeFile writes the data to the file and syncs it.
+func writeFile(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *File) Load() ([]Chat, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return sortedChats(s.chats), nil
+}
+
+func (s *File) Save(chat Chat) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.journal == nil {
+		return os.ErrClosed
+	}
+	if s.torn {
+		if err := s.compact(); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(entry{Seq: s.seq + 1, Chat: chat})
+	if err != nil {
+		return err
+	}
+	if err := s.append(append(data, '\n')); err != nil {
+		return err
+	}
+	s.seq++
+	s.chats[chat.ID] = chat
+	if s.journalSize >= minCompactSize && s.journalSize >= s.snapshotSize {
+		return s.compact()
+	}
+	return nil
+}
+
+// append appends the line to the journal and syncs it. If that fails, the
+// line is removed again, so that later entries are not appended to a partial
+// one. The caller must hold the lock.
+func (s *File) append(line []byte) error {
+	n, err := s.journal.Write(line)
+	if err == nil {
+		if err = s.journal.Sync(); err == nil {
+			s.journalSize += int64(n)
+			return nil
+		}
+	}
+	if terr := s.journal.Truncate(s.journalSize); terr != nil {
+		s.torn = true
+	} else if _, serr := s.journal.Seek(s.journalSize, io.SeekStart); serr != nil {
+		s.torn = true
+	}
+	return err
+}
+
+// Close merges the journal into the snapshot and closes the storage.
+func (s *File) Close() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.journal == nil {
+		return os.ErrClosed
+	}
+	if err := s.compact(); err != nil {
+		return err
+	}
+	err := s.journal.Close()
+	s.journal = nil
+	return err
+}
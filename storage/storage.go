@@ -0,0 +1,166 @@
+// Package storage keeps the state of chats across restarts.
+package storage
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+	"github.com/Teelevision/telegram-duebelwein-bot/room"
+)
+
+// Storage stores the state of chats. It must be safe for concurrent use.
+type Storage interface {
+	// Load returns all stored chats ordered by id.
+	Load() ([]Chat, error)
+	// Save stores the chat, replacing its previous state.
+	Save(chat Chat) error
+}
+
+// Chat is the state of a telegram chat and its room. Users are referred to
+// by their telegram id.
+type Chat struct {
+	ID      int64
+	Users   []User
+	Media   []Medium
+	History []Play
+	// Policy are the rules of the chat as formatted by room.FormatRule. It
+	// is nil if the chat uses the default policy.
+	Policy []string `json:",omitempty"`
+	// Ordering is how the queue is ordered, as the arguments of the /order
+	// command. It is empty if the queue is ordered by score.
+	Ordering string `json:",omitempty"`
+	// LastPlayedUser is the user whose media were played last and Streak how
+	// many in a row.
+	LastPlayedUser int `json:",omitempty"`
+	Streak         int `json:",omitempty"`
+}
+
+// User is a user in a chat.
+type User struct {
+	ID           int
+	Name         string
+	LastPlayedAt time.Time
+	LastActiveAt time.Time   `json:",omitempty"`
+	Submissions  []time.Time `json:",omitempty"`
+}
+
+// Medium is a queued or the current medium of a chat.
+type Medium struct {
+	Medium    medium.JSON
+	User      int
+	Metadata  metadata.Metadata
+	AddedAt   time.Time
+	Votes     []Vote `json:",omitempty"`
+	SkipVotes []int  `json:",omitempty"`
+	State     room.State
+	// DispatchedAt and StartedAt are set for the current medium.
+	DispatchedAt time.Time `json:",omitempty"`
+	StartedAt    time.Time `json:",omitempty"`
+	// Message is the id of the message with the vote buttons, which reply to
+	// the message with the id ReplyTo. Buttons is the unique part of the
+	// names of the buttons.
+	Message int    `json:",omitempty"`
+	ReplyTo int    `json:",omitempty"`
+	Buttons string `json:",omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The medium is nil if it cannot
+// be decoded.
+func (m *Medium) UnmarshalJSON(data []byte) error {
+	type plain Medium
+	var v struct {
+		plain
+		Medium json.RawMessage
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*m = Medium(v.plain)
+	m.Medium = decodeMedium(v.Medium)
+	return nil
+}
+
+// Vote is the vote of a user on a medium.
+type Vote struct {
+	User    int
+	Gravity int
+	At      time.Time
+}
+
+// Play is a medium that was played in a chat.
+type Play struct {
+	Medium   medium.JSON
+	Metadata metadata.Metadata
+	User     int
+	Score    int
+	AddedAt  time.Time
+	PlayedAt time.Time
+	EndedAt  time.Time
+	State    room.State
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The medium is nil if it cannot
+// be decoded.
+func (p *Play) UnmarshalJSON(data []byte) error {
+	type plain Play
+	var v struct {
+		plain
+		Medium json.RawMessage
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*p = Play(v.plain)
+	p.Medium = decodeMedium(v.Medium)
+	return nil
+}
+
+func decodeMedium(data json.RawMessage) medium.JSON {
+	var m medium.JSON
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &m); err != nil {
+			log.Printf("could not decode medium %s: %s", data, err)
+		}
+	}
+	return m
+}
+
+// Memory stores chats in memory. They are lost when the process ends.
+type Memory struct {
+	l     sync.Mutex
+	chats map[int64]Chat
+}
+
+// NewMemory returns an empty memory storage.
+func NewMemory() *Memory {
+	return &Memory{chats: make(map[int64]Chat)}
+}
+
+func (s *Memory) Load() ([]Chat, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return sortedChats(s.chats), nil
+}
+
+func (s *Memory) Save(chat Chat) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.chats[chat.ID] = chat
+	return nil
+}
+
+func sortedChats(chats map[int64]Chat) []Chat {
+	sorted := make([]Chat, 0, len(chats))
+	for _, chat := range chats {
+		sorted = append(sorted, chat)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
@@ -0,0 +1,169 @@
+package storage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+	"github.com/Teelevision/telegram-duebelwein-bot/room"
+	. "github.com/Teelevision/telegram-duebelwein-bot/storage"
+)
+
+func testChats(t *testing.T) []Chat {
+	now := time.Date(2020, 2, 2, 20, 0, 0, 0, time.UTC)
+	video, err := medium.New("https://youtu.be/YgGzAKP_HuM?t=95")
+	if err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	track, err := medium.New("https://soundcloud.com/fu-ggbeats/sludge")
+	if err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	return []Chat{{
+		ID:    -1002,
+		Users: []User{{ID: 1, Name: "Joakim", LastPlayedAt: now}, {ID: 2, Name: "Serj"}},
+		Media: []Medium{{
+			Medium:   medium.JSON{Medium: video},
+			User:     1,
+			Metadata: metadata.Metadata{Title: "Primo Victoria", Artist: "Sabaton", Duration: 4 * time.Minute},
+			AddedAt:  now,
+			Votes:    []Vote{{User: 2, Gravity: 1, At: now}},
+			State:    room.Queued,
+			Message:  12,
+			ReplyTo:  11,
+			Buttons:  "abc",
+		}},
+		History: []Play{{
+			Medium:   medium.JSON{Medium: track},
+			User:     2,
+			Score:    -1,
+			AddedAt:  now.Add(-time.Hour),
+			PlayedAt: now.Add(-time.Minute),
+			EndedAt:  now,
+			State:    room.Skipped,
+		}},
+		LastPlayedUser: 2,
+		Streak:         1,
+	}, {
+		ID: -1001,
+	}}
+}
+
+func TestMemory(t *testing.T) {
+	s := NewMemory()
+	want := testChats(t)
+	for i := len(want) - 1; i >= 0; i-- {
+		if err := s.Save(want[i]); err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+	}
+	if chats, err := s.Load(); err != nil || !reflect.DeepEqual(chats, want) {
+		t.Errorf("expected %+v, got %+v and %v", want, chats, err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	want := testChats(t)
+	expectChats := func(s *File, want []Chat) {
+		t.Helper()
+		chats, err := s.Load()
+		if err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+		if !reflect.DeepEqual(chats, want) {
+			t.Errorf("expected %+v, got %+v", want, chats)
+		}
+	}
+
+	s, err := OpenFile(dir)
+	if err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	expectChats(s, []Chat{})
+	for _, chat := range want {
+		if err := s.Save(chat); err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+	}
+	expectChats(s, want)
+
+	// restored from the journal without closing, like after a crash
+	s, err = OpenFile(dir)
+	if err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	expectChats(s, want)
+
+	// later states replace earlier ones and a cut off entry is ignored
+	want[1].Users = []User{{ID: 3, Name: "Tarja"}}
+	if err := s.Save(want[1]); err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	journal, err := os.OpenFile(filepath.Join(dir, "journal.jsonl"), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.WriteString(`{"Seq":100,"Chat":{"ID":-1002,"Us`)
+	journal.Close()
+	s, err = OpenFile(dir)
+	if err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	expectChats(s, want)
+
+	// media and plays that cannot be decoded anymore are dropped
+	journal, err = os.OpenFile(filepath.Join(dir, "journal.jsonl"), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.WriteString(`{"Seq":101,"Chat":{"ID":-1003,"Media":[{"Medium":{"uri":"gone:1"}}],"History":[{"Medium":{"uri":"gone:2"}}]}}` + "\n")
+	journal.Close()
+	s, err = OpenFile(dir)
+	if err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	want = append([]Chat{{ID: -1003, Media: []Medium{}, History: []Play{}}}, want...)
+	expectChats(s, want)
+
+	// a torn entry in the middle of the journal is skipped
+	if err := s.Save(want[1]); err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	journal, err = os.OpenFile(filepath.Join(dir, "journal.jsonl"), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.WriteString(`{"Seq":102,"Chat":{"ID":-1002,"Us` + "\n")
+	journal.WriteString(`{"Seq":103,"Chat":{"ID":-1004}}` + "\n")
+	journal.Close()
+	s, err = OpenFile(dir)
+	if err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	want = append([]Chat{{ID: -1004}}, want...)
+	expectChats(s, want)
+
+	// restored from the snapshot
+	if err := s.Close(); err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	if err := s.Save(want[0]); err != os.ErrClosed {
+		t.Errorf("expected error %q after closing, got %q", os.ErrClosed, err)
+	}
+	s, err = OpenFile(dir)
+	if err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	defer s.Close()
+	expectChats(s, want)
+}
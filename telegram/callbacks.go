@@ -0,0 +1,52 @@
+package telegram
+
+import (
+	"strings"
+	"sync"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// callbacks are the handlers of inline buttons by the unique names of the
+// buttons. Unlike the handlers of telebot, which must not change while the
+// bot is running, they can be changed at any time, e.g. when buttons expire.
+type callbacks struct {
+	sync.RWMutex
+	handlers map[string]func(*tb.Callback)
+}
+
+// handleButton handles the presses of buttons with the unique name. A nil
+// handler stops handling them.
+func (b *Bot) handleButton(unique string, handler func(*tb.Callback)) {
+	b.callbacks.Lock()
+	defer b.callbacks.Unlock()
+	if handler == nil {
+		delete(b.callbacks.handlers, unique)
+		return
+	}
+	if b.callbacks.handlers == nil {
+		b.callbacks.handlers = make(map[string]func(*tb.Callback))
+	}
+	b.callbacks.handlers[unique] = handler
+}
+
+// callback calls the handler of the pressed button with the data of the
+// button. Presses of unknown buttons are ignored. It handles the callbacks
+// that telebot has no handler for.
+func (b *Bot) callback(c *tb.Callback) {
+	if !strings.HasPrefix(c.Data, "\f") {
+		return
+	}
+	unique, data := c.Data[1:], ""
+	if i := strings.Index(unique, "|"); i >= 0 {
+		unique, data = unique[:i], unique[i+1:]
+	}
+	b.callbacks.RLock()
+	handler := b.callbacks.handlers[unique]
+	b.callbacks.RUnlock()
+	if handler == nil {
+		return
+	}
+	c.Data = data
+	handler(c)
+}
@@ -0,0 +1,130 @@
+package telegram
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+	"github.com/Teelevision/telegram-duebelwein-bot/search"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+const (
+	// maxInlineResults is the maximum number of results telegram accepts.
+	maxInlineResults = 50
+	// inlineSearchLimit is how many search results are shown inline.
+	inlineSearchLimit = 10
+)
+
+// inlineQuery answers inline queries with the queued and played media of the
+// rooms the user is in and, if search is enabled, with search results. A
+// picked result is sent as a link to the chat the user is in, where it is
+// queued like any other link. That way it ends up in the room of that chat.
+func (b *Bot) inlineQuery(q *tb.Query) {
+	query := strings.TrimSpace(q.Text)
+	results := b.queued(q.From.ID, query)
+	if b.searchBackend != nil && query != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		found, err := b.searchBackend.Search(ctx, query, inlineSearchLimit)
+		if err != nil {
+			log.Printf("could not search %q: %s", query, err)
+		}
+		results = append(results, found...)
+	}
+	err := b.telegram.Answer(q, &tb.QueryResponse{
+		Results:    inlineResults(results),
+		CacheTime:  10, // seconds, the queues change quickly
+		IsPersonal: true,
+	})
+	if err != nil {
+		log.Printf("could not answer inline query %q: %s", query, err)
+	}
+}
+
+// queued returns the queued media of all rooms the user is in, followed by
+// their played media, that match the query. All are returned if the query is
+// empty. Media that are queued or were played more than once are returned
+// once.
+func (b *Bot) queued(userID int, query string) []search.Result {
+	b.RLock()
+	all := make([]*chat, 0, len(b.chats))
+	for _, chat := range b.chats {
+		all = append(all, chat)
+	}
+	b.RUnlock()
+	var chats []*chat
+	for _, chat := range all {
+		chat.RLock()
+		if _, member := chat.users[userID]; member {
+			chats = append(chats, chat)
+		}
+		chat.RUnlock()
+	}
+
+	var results search.Static
+	add := func(m medium.Medium, md metadata.Metadata) {
+		for _, r := range results {
+			if medium.Identical(r.Medium, m) {
+				return
+			}
+		}
+		results = append(results, search.Result{Medium: m, Metadata: md})
+	}
+	for _, chat := range chats {
+		for _, item := range chat.Queue() {
+			md, _ := chat.GetMediumMetadata(item.Medium)
+			add(item.Medium, md)
+		}
+	}
+	for _, chat := range chats {
+		for _, play := range chat.History() {
+			add(play.Medium, play.Metadata)
+		}
+	}
+	if query == "" {
+		return results
+	}
+	results, _ = results.Search(context.Background(), query, len(results))
+	return results
+}
+
+// inlineResults returns an inline result per medium that sends its link.
+// Duplicates and media without link are left out.
+func inlineResults(results []search.Result) tb.Results {
+	var (
+		inline tb.Results
+		seen   []medium.Medium
+	)
+results:
+	for _, r := range results {
+		link := medium.URL(r.Medium)
+		if link == "" || len(inline) == maxInlineResults {
+			continue
+		}
+		for _, m := range seen {
+			if medium.Identical(m, r.Medium) {
+				continue results
+			}
+		}
+		seen = append(seen, r.Medium)
+
+		title := r.Metadata.String()
+		if title == "" {
+			title = link
+		}
+		result := &tb.ArticleResult{
+			Title:       title,
+			Text:        link,
+			Description: link,
+			ThumbURL:    r.Metadata.Thumbnail,
+		}
+		result.SetResultID(strconv.Itoa(len(inline)))
+		inline = append(inline, result)
+	}
+	return inline
+}
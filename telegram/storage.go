@@ -0,0 +1,263 @@
+package telegram
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/room"
+	"github.com/Teelevision/telegram-duebelwein-bot/storage"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Storage sets the storage that keeps the chats across restarts. By default,
+// chats are not kept. It must be called before the bot is started.
+func (b *Bot) Storage(s storage.Storage) {
+	b.storage = s
+}
+
+// changed saves the chat in the background, if there is a storage.
+func (b *Bot) changed(chat *chat) {
+	if b.storage == nil {
+		return
+	}
+	select {
+	case chat.changed <- struct{}{}:
+	default: // will be saved anyway
+	}
+}
+
+// saveChanges saves the chat every time it changed until the bot stops.
+// Changes that happen while it is saved are saved together afterwards.
+func (b *Bot) saveChanges(chat *chat) {
+	defer b.savers.Done()
+	for {
+		select {
+		case <-chat.changed:
+		case <-b.stopped:
+			return
+		}
+		chat.RLock()
+		stored := storedChat(chat)
+		chat.RUnlock()
+		if err := b.storage.Save(stored); err != nil {
+			log.Printf("could not save chat %d: %s", chat.id, err)
+		}
+	}
+}
+
+// storedChat returns the chat as it is stored. The caller must hold the read
+// lock of the chat.
+func storedChat(chat *chat) storage.Chat {
+	snapshot := chat.Snapshot()
+	stored := storage.Chat{
+		ID:             chat.id,
+		LastPlayedUser: userID(snapshot.LastPlayedUser),
+		Streak:         snapshot.Streak,
+	}
+	if ordering := chat.Ordering(); ordering != nil && ordering != (room.ByScore{}) {
+		stored.Ordering = formatOrdering(ordering)
+	}
+	if chat.customPolicy {
+		stored.Policy = []string{}
+		for _, rule := range chat.Policy() {
+			lines, ok := room.FormatRule(rule)
+			if !ok {
+				log.Printf("cannot store rule %q of chat %d", rule, chat.id)
+			}
+			stored.Policy = append(stored.Policy, lines...)
+		}
+	}
+	for _, u := range snapshot.Users {
+		su := storage.User{
+			ID:           userID(u.User),
+			LastPlayedAt: u.LastPlayedAt,
+			LastActiveAt: u.LastActiveAt,
+			Submissions:  u.Submissions,
+		}
+		if u, ok := u.User.(*user); ok {
+			su.Name = u.Name
+		}
+		stored.Users = append(stored.Users, su)
+	}
+	sort.Slice(stored.Users, func(i, j int) bool {
+		return stored.Users[i].ID < stored.Users[j].ID
+	})
+	for _, ms := range snapshot.Media {
+		sm := storage.Medium{
+			Medium:       medium.JSON{Medium: ms.Medium},
+			User:         userID(ms.User),
+			Metadata:     ms.Metadata,
+			AddedAt:      ms.AddedAt,
+			State:        ms.State,
+			DispatchedAt: ms.DispatchedAt,
+			StartedAt:    ms.StartedAt,
+		}
+		for user, gravity := range ms.Votes {
+			sm.Votes = append(sm.Votes, storage.Vote{User: userID(user), Gravity: gravity, At: ms.VotedAt[user]})
+		}
+		sort.Slice(sm.Votes, func(i, j int) bool {
+			return sm.Votes[i].User < sm.Votes[j].User
+		})
+		for _, user := range ms.SkipVotes {
+			sm.SkipVotes = append(sm.SkipVotes, userID(user))
+		}
+		sort.Ints(sm.SkipVotes)
+		if ctx, ok := chat.media[ms.Medium]; ok && ctx.voteMessage != nil {
+			sm.Message = ctx.voteMessage.ID
+			sm.ReplyTo = ctx.originalMessage.ID
+			sm.Buttons = ctx.buttons.id
+		}
+		stored.Media = append(stored.Media, sm)
+	}
+	for _, play := range snapshot.History {
+		stored.History = append(stored.History, storage.Play{
+			Medium:   medium.JSON{Medium: play.Medium},
+			Metadata: play.Metadata,
+			User:     userID(play.User),
+			Score:    play.Score,
+			AddedAt:  play.AddedAt,
+			PlayedAt: play.PlayedAt,
+			EndedAt:  play.EndedAt,
+			State:    play.State,
+		})
+	}
+	return stored
+}
+
+func userID(u interface{}) int {
+	if u, ok := u.(*user); ok {
+		return u.ID
+	}
+	return 0
+}
+
+// restore restores the chats from the storage and handles the vote buttons
+// of their media again.
+func (b *Bot) restore() error {
+	if b.storage == nil {
+		return nil
+	}
+	chats, err := b.storage.Load()
+	if err != nil {
+		return err
+	}
+	for _, stored := range chats {
+		b.restoreChat(stored)
+	}
+	return nil
+}
+
+func (b *Bot) restoreChat(stored storage.Chat) {
+	chat := b.seeChat(stored.ID)
+	chat.Lock()
+	defer chat.Unlock()
+
+	// policy
+	if stored.Policy != nil {
+		var rules []room.Rule
+		for _, line := range stored.Policy {
+			rule, err := room.ParseRule(line)
+			if err != nil {
+				log.Printf("could not restore rule %q of chat %d: %s", line, stored.ID, err)
+				continue
+			}
+			rules = room.WithRule(rules, rule)
+		}
+		chat.SetPolicy(rules...)
+		chat.customPolicy = true
+	}
+
+	// ordering
+	if stored.Ordering != "" {
+		ordering, err := parseOrdering(stored.Ordering)
+		if err != nil {
+			log.Printf("could not restore order %q of chat %d: %s", stored.Ordering, stored.ID, err)
+		} else {
+			chat.SetOrdering(ordering)
+		}
+	}
+
+	// users
+	snapshot := room.Snapshot{Streak: stored.Streak}
+	for _, su := range stored.Users {
+		u := &user{ID: su.ID, Name: su.Name}
+		chat.users[su.ID] = u
+		snapshot.Users = append(snapshot.Users, room.UserSnapshot{
+			User:         u,
+			LastPlayedAt: su.LastPlayedAt,
+			LastActiveAt: su.LastActiveAt,
+			Submissions:  su.Submissions,
+		})
+	}
+	// ref returns the user with the id or nil if it is unknown
+	ref := func(id int) interface{} {
+		if u, ok := chat.users[id]; ok {
+			return u
+		}
+		return nil
+	}
+	snapshot.LastPlayedUser = ref(stored.LastPlayedUser)
+
+	// media and their votes
+	media := make(map[medium.Medium]storage.Medium, len(stored.Media))
+	for _, sm := range stored.Media {
+		user := ref(sm.User)
+		if user == nil || sm.Medium.Medium == nil {
+			continue
+		}
+		ms := room.MediumSnapshot{
+			Medium:       sm.Medium.Medium,
+			User:         user,
+			Metadata:     sm.Metadata,
+			AddedAt:      sm.AddedAt,
+			Votes:        make(map[interface{}]int, len(sm.Votes)),
+			VotedAt:      make(map[interface{}]time.Time, len(sm.Votes)),
+			State:        sm.State,
+			DispatchedAt: sm.DispatchedAt,
+			StartedAt:    sm.StartedAt,
+		}
+		for _, vote := range sm.Votes {
+			if voter := ref(vote.User); voter != nil {
+				ms.Votes[voter], ms.VotedAt[voter] = vote.Gravity, vote.At
+			}
+		}
+		for _, id := range sm.SkipVotes {
+			if voter := ref(id); voter != nil {
+				ms.SkipVotes = append(ms.SkipVotes, voter)
+			}
+		}
+		snapshot.Media = append(snapshot.Media, ms)
+		media[ms.Medium] = sm
+	}
+
+	// history
+	for _, play := range stored.History {
+		if play.Medium.Medium == nil {
+			continue
+		}
+		snapshot.History = append(snapshot.History, room.Play{
+			Medium:   play.Medium.Medium,
+			Metadata: play.Metadata,
+			User:     ref(play.User),
+			Score:    play.Score,
+			AddedAt:  play.AddedAt,
+			PlayedAt: play.PlayedAt,
+			EndedAt:  play.EndedAt,
+			State:    play.State,
+		})
+	}
+
+	// the vote messages of the restored media
+	for _, q := range chat.Restore(snapshot) {
+		sm := media[q.Medium]
+		if sm.Message == 0 {
+			go b.saveStates(chat, q.States)
+			continue
+		}
+		msg := &tb.Message{ID: sm.ReplyTo, Chat: &tb.Chat{ID: stored.ID}}
+		voteMsg := &tb.Message{ID: sm.Message, Chat: msg.Chat}
+		b.handleVoteButtons(chat, msg, voteMsg, voteButtonsOf(sm.Buttons), q.Medium, q.States)
+	}
+}
@@ -1,16 +1,23 @@
 package telegram
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"log"
-	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf16"
 
 	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
 	"github.com/Teelevision/telegram-duebelwein-bot/room"
+	"github.com/Teelevision/telegram-duebelwein-bot/search"
+	"github.com/Teelevision/telegram-duebelwein-bot/storage"
 	tb "gopkg.in/tucnak/telebot.v2"
 )
 
@@ -20,21 +27,49 @@ type Bot struct {
 	sync.RWMutex
 	chats             map[int64]*chat
 	playerURLTemplate string
+
+	collectionResolver medium.CollectionResolver
+	maxCollectionSize  int
+	metadataResolver   metadata.Resolver
+	sniffFiles         bool
+	unwrapper          *medium.Unwrapper
+	probableReposts    bool
+	policy             []room.Rule
+	limits             room.Limits
+	aging              room.Aging
+	skipThreshold      room.SkipThreshold
+	repostCooldown     time.Duration
+	searchBackend      search.Backend
+	storage            storage.Storage
+	searches           *search.Searches
+	uploads            uploads
+	callbacks          callbacks
+	stopped            chan struct{}  // closed once the bot stops
+	savers             sync.WaitGroup // saving the changes of chats
 }
 
 type chat struct {
 	*room.Room
 	sync.RWMutex
+	id    int64
 	users map[int]*user
 	media map[medium.Medium]*mediumContext
+	// customPolicy is whether the policy was changed in the chat and differs
+	// from the one of the bot
+	customPolicy bool
+	// receiving when the chat changed and needs to be saved
+	changed chan struct{}
 }
 
 type user struct {
-	ID int
+	ID   int
+	Name string
 }
 
 type mediumContext struct {
 	originalMessage *tb.Message
+	voteMessage     *tb.Message
+	buttons         *voteButtons
 	cleanUp         func(why string)
 }
 
@@ -51,11 +86,16 @@ func NewBot(token, playerURLTemplate string) (*Bot, error) {
 		telegram:          tbBot,
 		chats:             make(map[int64]*chat),
 		playerURLTemplate: playerURLTemplate,
+		stopped:           make(chan struct{}),
 	}, nil
 }
 
-// Start starts the bot.
+// Start starts the bot. The chats are restored from the storage first.
 func (b *Bot) Start() {
+	if err := b.restore(); err != nil {
+		log.Printf("could not restore chats: %s", err)
+	}
+
 	b.telegram.Handle(tb.OnAddedToGroup, func(msg *tb.Message) {
 		if !msg.FromGroup() {
 			return
@@ -70,7 +110,7 @@ func (b *Bot) Start() {
 		if !msg.FromGroup() || msg.UserJoined == nil {
 			return
 		}
-		b.seeUser(msg.Chat.ID, msg.UserJoined.ID)
+		b.seeUser(msg.Chat.ID, msg.UserJoined)
 	})
 
 	b.telegram.Handle(tb.OnUserLeft, func(msg *tb.Message) {
@@ -79,9 +119,12 @@ func (b *Bot) Start() {
 		if !msg.FromGroup() || msg.UserLeft == nil {
 			return
 		}
-		chat, user := b.seeUser(msg.Chat.ID, msg.UserLeft.ID)
+		chat, user := b.seeUser(msg.Chat.ID, msg.UserLeft)
 		chat.UserLeaves(user)
+		chat.Lock()
 		delete(chat.users, msg.UserLeft.ID)
+		chat.Unlock()
+		b.changed(chat)
 	})
 
 	// TODO: just for testing
@@ -92,105 +135,972 @@ func (b *Bot) Start() {
 		chat := b.seeChat(msg.Chat.ID)
 		queue := chat.Queue()
 		if len(queue) > 0 {
-			m := queue[0]
-			chat.MediumPlayed(m)
-			chat.RLock()
-			mediumCtx, ok := chat.media[m]
-			chat.RUnlock()
-			if ok {
-				b.telegram.Send(msg.Chat, fmt.Sprintf("%s (%s)", m.ID(), m.Provider()))
-				mediumCtx.cleanUp("played")
-			}
+			m := queue[0].Medium
+			b.telegram.Send(msg.Chat, fmt.Sprintf("%s (%s)", chat.title(m), m.Provider()))
+			chat.MediumPlayed(m) // cleans up the vote buttons
+		}
+	})
+
+	b.telegram.Handle("/policy", func(msg *tb.Message) {
+		if !msg.FromGroup() {
+			return
 		}
+		if strings.TrimSpace(msg.Payload) != "" && !b.isAdmin(msg.Chat, msg.Sender) {
+			b.reply(msg, "Only admins can change the policy")
+			return
+		}
+		b.reply(msg, b.changePolicy(b.seeChat(msg.Chat.ID), msg.Payload))
 	})
 
-	b.telegram.Handle(tb.OnText, func(msg *tb.Message) {
+	b.telegram.Handle("/history", func(msg *tb.Message) {
 		if !msg.FromGroup() {
 			return
 		}
-		chat, user := b.seeUser(msg.Chat.ID, msg.Sender.ID)
+		chat := b.seeChat(msg.Chat.ID)
+		chat.RLock() // user names
+		text := historyText(chat.History(), time.Now())
+		chat.RUnlock()
+		b.reply(msg, text)
+	})
+
+	b.telegram.Handle("/order", func(msg *tb.Message) {
+		if !msg.FromGroup() {
+			return
+		}
+		if strings.TrimSpace(msg.Payload) != "" && !b.isAdmin(msg.Chat, msg.Sender) {
+			b.reply(msg, "Only admins can change the order")
+			return
+		}
+		b.reply(msg, b.changeOrdering(b.seeChat(msg.Chat.ID), msg.Payload))
+	})
+
+	b.telegram.Handle(tb.OnCallback, b.callback)
+	b.telegram.Handle("/q", b.queueSearch)
+	b.telegram.Handle(tb.OnQuery, b.inlineQuery)
+
+	b.telegram.Handle(tb.OnText, b.queueLinks)
+	b.telegram.Handle(tb.OnPhoto, b.queueLinks)
+	b.telegram.Handle(tb.OnVideo, b.queueLinks)
+	b.telegram.Handle(tb.OnAudio, b.queueUpload)
+	b.telegram.Handle(tb.OnVoice, b.queueUpload)
+	b.telegram.Handle(tb.OnDocument, b.queueUpload)
+
+	b.telegram.Start()
+}
+
+// Stop stops receiving updates and saves all chats, if there is a storage.
+// Once it returns, the bot does not use the storage anymore, so that it can
+// be closed. The bot must be started.
+func (b *Bot) Stop() {
+	b.telegram.Stop()
+	b.Lock()
+	close(b.stopped)
+	chats := make([]*chat, 0, len(b.chats))
+	for _, chat := range b.chats {
+		chats = append(chats, chat)
+	}
+	b.Unlock()
+	if b.storage == nil {
+		return
+	}
+	b.savers.Wait()
+	for _, chat := range chats {
+		chat.RLock()
+		stored := storedChat(chat)
+		chat.RUnlock()
+		if err := b.storage.Save(stored); err != nil {
+			log.Printf("could not save chat %d: %s", chat.id, err)
+		}
+	}
+}
+
+// queueLinks queues the media of all links in the text or caption of the
+// message. If there is more than one link, a summary is sent.
+func (b *Bot) queueLinks(msg *tb.Message) {
+	if !msg.FromGroup() {
+		return
+	}
+	links := getURLs(msg)
+	if len(links) == 0 {
+		if msg.Text != "" && b.searches != nil {
+			b.reply(msg, "Wat?! Try /q <artist - title>")
+		} else if msg.Text != "" {
+			b.reply(msg, "Wat?!")
+		}
+		return
+	}
+	chat, user := b.seeUser(msg.Chat.ID, msg.Sender)
 
-		// try to get the medium, if one was sent
-		url := getFirstURL(msg)
-		m, err := medium.New(url)
+	results := make([]queueResult, len(links))
+	for i, link := range links {
+		results[i] = b.queueLink(chat, user, msg, link)
+	}
+
+	// a single queued link is answered by its vote buttons
+	if len(results) == 1 {
+		if err := results[0].err; err != nil {
+			b.replyError(msg, err)
+		}
+		return
+	}
+	var reposts []*room.ProbableRepostError
+	var labels []string
+	for _, r := range results {
+		if repost, ok := r.err.(*room.ProbableRepostError); ok {
+			reposts = append(reposts, repost)
+			labels = append(labels, buttonText("Queue anyway: "+r.link))
+		}
+	}
+	if len(reposts) == 0 {
+		b.reply(msg, summary(results))
+		return
+	}
+	b.replyAnyway(msg, summary(results), reposts, labels)
+}
+
+// queueSearch searches media by the text of the command and shows the results
+// as buttons. The medium the user picks is queued.
+func (b *Bot) queueSearch(msg *tb.Message) {
+	if !msg.FromGroup() {
+		return
+	}
+	if b.searches == nil {
+		b.reply(msg, "Search is not available")
+		return
+	}
+	query := strings.TrimSpace(msg.Payload)
+	if query == "" {
+		b.reply(msg, "Usage: /q <artist - title>")
+		return
+	}
+	_, user := b.seeUser(msg.Chat.ID, msg.Sender)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	token, results, err := b.searches.Search(ctx, user, query)
+	if err != nil {
+		log.Printf("could not search %q: %s", query, err)
+		b.reply(msg, "error")
+		return
+	}
+	if len(results) == 0 {
+		b.reply(msg, "Nothing found")
+		return
+	}
+
+	// show a button per result
+	pick := tb.InlineButton{Unique: "pick" + token}
+	dismiss := tb.InlineButton{Unique: "dismiss" + token, Text: "❌"}
+	keyboard := make([][]tb.InlineButton, 0, len(results)+1)
+	for i, r := range results {
+		keyboard = append(keyboard, []tb.InlineButton{{
+			Unique: pick.Unique,
+			Text:   buttonText(r.Metadata.String()),
+			Data:   strconv.Itoa(i),
+		}})
+	}
+	keyboard = append(keyboard, []tb.InlineButton{dismiss})
+	sendOpt := &tb.SendOptions{
+		ReplyTo:     msg,
+		ReplyMarkup: &tb.ReplyMarkup{InlineKeyboard: keyboard},
+	}
+	resultsMsg, err := b.telegram.Send(msg.Chat, "Pick one:", sendOpt)
+	if err != nil {
+		log.Printf("could not show the results of %q: %s", query, err)
+		b.searches.Cancel(token, user)
+		return
+	}
+
+	var once sync.Once
+	done := func(text string) {
+		once.Do(func() {
+			// release resources so that the gc can do the rest
+			b.handleButton(pick.Unique, nil)
+			b.handleButton(dismiss.Unique, nil)
+			sendOpt.ReplyMarkup = nil
+			b.telegram.Edit(resultsMsg, text, sendOpt)
+		})
+	}
+	b.handleButton(pick.Unique, func(c *tb.Callback) {
+		chat, user := b.seeUser(msg.Chat.ID, c.Sender)
+		index, _ := strconv.Atoi(c.Data)
+		r, err := b.searches.Pick(token, user, index)
 		if err != nil {
-			// reply that no medium could be found and abort
-			b.telegram.Send(msg.Chat, "Wat?!", tb.Silent, &tb.SendOptions{
-				ReplyTo: msg,
-			})
-			log.Printf("could not load medium from %q: %s", url, err)
+			b.telegram.Respond(c, &tb.CallbackResponse{Text: searchErrorText(err)})
 			return
 		}
+		b.telegram.Respond(c, &tb.CallbackResponse{Text: "Picked!"})
+		done("Picked: " + r.Metadata.String())
+		if _, err := b.queueMedium(chat, user, msg, r.Medium, false); err != nil {
+			b.replyError(msg, err)
+		}
+	})
+	b.handleButton(dismiss.Unique, func(c *tb.Callback) {
+		_, user := b.seeUser(msg.Chat.ID, c.Sender)
+		if err := b.searches.Cancel(token, user); err != nil {
+			b.telegram.Respond(c, &tb.CallbackResponse{Text: searchErrorText(err)})
+			return
+		}
+		b.telegram.Respond(c, &tb.CallbackResponse{})
+		done("Dismissed")
+	})
+	// the search is forgotten if nobody picks a result in time
+	time.AfterFunc(b.searches.TTL(), func() { done("Expired") })
+}
+
+// searchErrorText returns the answer to an error that occurred when picking a
+// search result.
+func searchErrorText(err error) string {
+	switch err {
+	case search.ErrNotYourSearch:
+		return "Not your search!"
+	case search.ErrSearchUnknown:
+		return "Too late!"
+	}
+	return "error"
+}
+
+// buttonText shortens the text to fit on a button.
+func buttonText(text string) string {
+	const max = 60
+	if r := []rune(text); len(r) > max {
+		return string(r[:max-1]) + "…"
+	}
+	return text
+}
+
+// queueResult is the result of queuing the media of a link.
+type queueResult struct {
+	link   string
+	titles []string // of the queued media
+	err    error
+}
+
+// queueLink queues the medium or, if expanding collections is enabled, the
+// collection of the link and shows vote buttons for each queued medium.
+func (b *Bot) queueLink(chat *chat, user *user, msg *tb.Message, link string) queueResult {
+	result := queueResult{link: link}
+
+	// unwrap short links, if enabled
+	if b.unwrapper != nil {
+		if target, err := b.unwrapper.Unwrap(context.Background(), link); err != nil {
+			log.Printf("could not unwrap %q: %s", link, err)
+		} else {
+			link = target
+		}
+	}
+
+	// try to get a collection, if expanding them is enabled
+	if b.collectionResolver != nil {
+		if c, err := medium.NewCollection(link); err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			queued, err := chat.UserQueuesCollection(ctx, user, c)
+			switch {
+			case err == medium.ErrNotSupported:
+				result.err = room.ErrMediumIsCollection
+			case err != nil:
+				result.err = err
+			}
+			if result.err != nil {
+				log.Printf("could not queue collection from %q: %s", link, result.err)
+				return result
+			}
+
+			chat.Lock() // lock until clean up funcs are created
+			defer chat.Unlock()
+			for _, q := range queued {
+				b.showVoteButtons(chat, msg, q.Medium, q.States)
+				result.titles = append(result.titles, chat.title(q.Medium))
+			}
+			return result
+		}
+	}
+
+	// try to get the medium
+	m, err := medium.New(link)
+	if err == medium.ErrNotSupported && b.sniffFiles {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		m, err = medium.SniffFile(ctx, nil, link)
+	}
+	if err != nil {
+		log.Printf("could not load medium from %q: %s", link, err)
+		result.err = err
+		return result
+	}
+
+	title, err := b.queueMedium(chat, user, msg, m, false)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.titles = append(result.titles, title)
+	return result
+}
+
+// queueUpload queues the audio file, voice note or audio document of the
+// message.
+func (b *Bot) queueUpload(msg *tb.Message) {
+	if !msg.FromGroup() {
+		return
+	}
+	var (
+		fileID string
+		md     metadata.Metadata
+	)
+	switch {
+	case msg.Audio != nil:
+		fileID = msg.Audio.FileID
+		md = metadata.Metadata{
+			Title:    msg.Audio.Title,
+			Artist:   msg.Audio.Performer,
+			Duration: time.Duration(msg.Audio.Duration) * time.Second,
+		}
+		if md.Title == "" {
+			md.Title = msg.Audio.FileName
+		}
+	case msg.Voice != nil:
+		fileID = msg.Voice.FileID
+		md = metadata.Metadata{
+			Title:    "Voice note by " + msg.Sender.FirstName,
+			Duration: time.Duration(msg.Voice.Duration) * time.Second,
+		}
+	case msg.Document != nil && strings.HasPrefix(msg.Document.MIME, "audio/"):
+		fileID = msg.Document.FileID
+		md = metadata.Metadata{Title: msg.Document.FileName}
+	default:
+		return
+	}
+	m, err := medium.NewTelegramFile(fileID)
+	if err != nil {
+		log.Printf("could not load medium from file %q: %s", fileID, err)
+		return
+	}
+	b.uploads.Store(fileID, md)
+
+	chat, user := b.seeUser(msg.Chat.ID, msg.Sender)
+	_, err = b.queueMedium(chat, user, msg, m, false)
+	if _, ok := err.(*room.ProbableRepostError); !ok {
+		b.uploads.Delete(fileID) // kept by the room if queued
+	}
+	if err != nil {
+		b.replyError(msg, err)
+	}
+}
+
+// queueMedium queues the medium and shows vote buttons for it. It returns the
+// title of the medium. Probable reposts are only queued anyway if told so.
+func (b *Bot) queueMedium(chat *chat, user *user, msg *tb.Message, m medium.Medium, anyway bool) (string, error) {
+	queue := chat.UserQueuesMedium
+	if anyway {
+		queue = chat.UserQueuesMediumAnyway
+	}
+	// queue without the lock, as resolving the metadata may take a while
+	states, err := queue(user, m)
+	if err != nil {
+		log.Printf("could not queue medium: %s", err)
+		return "", err
+	}
+
+	chat.Lock() // lock until clean up func is created
+	defer chat.Unlock()
+	b.showVoteButtons(chat, msg, m, states)
+	return chat.title(m), nil
+}
+
+// uploads resolves the metadata of files uploaded to telegram, which is known
+// from the message they were sent with. The metadata is only needed while the
+// file is queued, as the room keeps it afterwards. The vendored telebot does
+// not know unique file ids, so files are referred to by their file id.
+type uploads struct {
+	sync.Map // file id -> metadata.Metadata
+}
+
+func (u *uploads) Resolve(_ context.Context, m medium.Medium) (metadata.Metadata, error) {
+	if m.Provider() != medium.ProviderTelegram {
+		return metadata.Metadata{}, medium.ErrNotSupported
+	}
+	md, ok := u.Load(fmt.Sprint(m.ID()))
+	if !ok {
+		return metadata.Metadata{}, nil
+	}
+	return md.(metadata.Metadata), nil
+}
+
+// errorText returns the reply to an error that occurred when queuing a link.
+func errorText(err error) string {
+	switch err := err.(type) {
+	case *room.ProbableRepostError:
+		return "Probable repost of " + originalText(err)
+	case *room.RecentlyPlayedError:
+		return "REEEEEEEpost, played " + ago(time.Since(err.Play.PlayedAt))
+	case *room.PolicyViolationError:
+		return "Not allowed: " + err.Reason
+	case *room.QuotaExceededError:
+		return fmt.Sprintf("You have %d songs queued already. You can queue again once one of them is played.", err.Max)
+	case *room.RateLimitError:
+		wait := time.Until(err.RetryAt).Round(time.Second)
+		return fmt.Sprintf("Slow down! You can queue again in %s.", wait)
+	}
+	switch err {
+	case medium.ErrNotSupported, medium.ErrInvalidURL:
+		return "Wat?!"
+	case room.ErrMediumAlreadyExists:
+		return "REEEEEEEpost"
+	case room.ErrMediumIsCollection:
+		return "Playlists and albums are not supported"
+	case room.ErrNothingQueued:
+		return "Nothing in the playlist could be queued"
+	}
+	return "error"
+}
+
+// originalText returns the title of the medium the probable repost was taken
+// for, as the room knows it, and its url.
+func originalText(err *room.ProbableRepostError) string {
+	title, url := err.Metadata.Title, medium.URL(err.Original)
+	switch {
+	case title == "" && url == "":
+		return medium.URI(err.Original)
+	case title == "":
+		return url
+	case url == "":
+		return title
+	}
+	return fmt.Sprintf("%s (%s)", title, url)
+}
+
+// orderings are the strategies the queue of a chat can be ordered by. Round
+// robin lets users play up to n songs in a row.
+var orderings = map[string]func(n int) room.OrderingStrategy{
+	"score":      func(int) room.OrderingStrategy { return room.ByScore{} },
+	"fifo":       func(int) room.OrderingStrategy { return room.FIFO{} },
+	"roundrobin": func(n int) room.OrderingStrategy { return room.RoundRobin{MaxConsecutive: n} },
+	"shuffle": func(int) room.OrderingStrategy {
+		return room.NewWeightedShuffle(time.Now().UnixNano())
+	},
+}
+
+// orderingNames returns the names of the orderings in alphabetical order.
+func orderingNames() []string {
+	names := make([]string, 0, len(orderings))
+	for name := range orderings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// orderingUsage explains how to change the ordering of a chat.
+func orderingUsage() string {
+	return fmt.Sprintf("Usage: /order <%s> [max songs in a row]", strings.Join(orderingNames(), "|"))
+}
+
+// parseOrdering returns the ordering of the arguments of /order, e.g.
+// "roundrobin 2".
+func parseOrdering(s string) (room.OrderingStrategy, error) {
+	args := strings.Fields(strings.ToLower(s))
+	if len(args) == 0 || len(args) > 2 {
+		return nil, fmt.Errorf("invalid order: %s", s)
+	}
+	newOrdering, ok := orderings[args[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown order: %s", args[0])
+	}
+	n := 1
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid number of songs in a row: %s", args[1])
+		}
+	}
+	return newOrdering(n), nil
+}
+
+// formatOrdering returns the arguments of /order that select the ordering.
+func formatOrdering(ordering room.OrderingStrategy) string {
+	if rr, ok := ordering.(room.RoundRobin); ok && rr.MaxConsecutive > 1 {
+		return fmt.Sprintf("%s %d", rr, rr.MaxConsecutive)
+	}
+	return ordering.String()
+}
+
+// changeOrdering changes how the queue of the chat is ordered. It returns the
+// reply, which names the ordering if there is no change.
+func (b *Bot) changeOrdering(chat *chat, change string) string {
+	if strings.TrimSpace(change) == "" {
+		return fmt.Sprintf("The queue is ordered by %s. %s", formatOrdering(chat.Ordering()), orderingUsage())
+	}
+	ordering, err := parseOrdering(change)
+	if err != nil {
+		return err.Error() + "\n" + orderingUsage()
+	}
+	chat.SetOrdering(ordering)
+	b.changed(chat)
+	return "The queue is now ordered by " + formatOrdering(ordering)
+}
 
-		// add the medium to the room
-		chat.Lock() // lock until clean up func is created
-		defer chat.Unlock()
-		played, err := chat.UserQueuesMedium(user, m)
+// isAdmin returns whether the user is an administrator of the chat.
+func (b *Bot) isAdmin(chat *tb.Chat, user *tb.User) bool {
+	member, err := b.telegram.ChatMemberOf(chat, user)
+	if err != nil {
+		log.Printf("could not get member %d of chat %d: %s", user.ID, chat.ID, err)
+		return false
+	}
+	return member.Role == tb.Creator || member.Role == tb.Administrator
+}
+
+// policyUsage explains how to change the policy of a chat.
+const policyUsage = "Usage: /policy <max 10:00|min 0:30|providers youtube soundcloud|block <link>|artist <name>|keyword <word>|known|reset>"
+
+// changePolicy adds the rule to the policy of the chat or resets it to the
+// policy of the bot. It returns the reply, which lists the rules if there is
+// no change.
+func (b *Bot) changePolicy(chat *chat, change string) string {
+	change = strings.TrimSpace(change)
+	if change == "" {
+		return policyText(chat.Policy())
+	}
+	chat.Lock()
+	defer chat.Unlock()
+	if strings.EqualFold(change, "reset") {
+		chat.SetPolicy(b.policy...)
+		chat.customPolicy = false
+	} else {
+		rule, err := room.ParseRule(change)
 		if err != nil {
-			resp := "error"
-			if err == room.ErrMediumAlreadyExists {
-				resp = "REEEEEEEpost"
+			return err.Error() + "\n" + policyUsage
+		}
+		chat.SetPolicy(room.WithRule(chat.Policy(), rule)...)
+		chat.customPolicy = true
+	}
+	b.changed(chat)
+	return policyText(chat.Policy())
+}
+
+// policyText returns a message that lists the rules of the policy.
+func policyText(rules []room.Rule) string {
+	if len(rules) == 0 {
+		return "Everything goes!"
+	}
+	lines := []string{"Media must be:"}
+	for _, rule := range rules {
+		lines = append(lines, "• "+rule.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// historySize is how many plays /history lists.
+const historySize = 10
+
+// historyText returns a message that lists the most recent plays.
+func historyText(plays []room.Play, now time.Time) string {
+	if len(plays) == 0 {
+		return "Nothing was played, yet."
+	}
+	if len(plays) > historySize {
+		plays = plays[:historySize]
+	}
+	lines := []string{"Recently played:"}
+	for _, play := range plays {
+		title := play.Metadata.String()
+		if title == "" {
+			title = medium.URL(play.Medium)
+		}
+		if title == "" {
+			title = medium.URI(play.Medium)
+		}
+		line := fmt.Sprintf("• %s (score: %d)", title, play.Score)
+		if play.State == room.Skipped {
+			line += " ⏭"
+		}
+		if u, ok := play.User.(*user); ok && u.Name != "" {
+			line += " by " + u.Name
+		}
+		lines = append(lines, line+", "+ago(now.Sub(play.PlayedAt)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ago returns how long ago something happened in words, e.g. "5m ago".
+func ago(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", d/time.Minute)
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", d/time.Hour)
+	}
+	return fmt.Sprintf("%dd ago", d/(24*time.Hour))
+}
+
+// summary returns a message that lists what was queued and what was not.
+func summary(results []queueResult) string {
+	var queued, failed []string
+	for _, r := range results {
+		for _, title := range r.titles {
+			queued = append(queued, "✅ "+title)
+		}
+		if r.err != nil {
+			failed = append(failed, fmt.Sprintf("❌ %s: %s", r.link, errorText(r.err)))
+		}
+	}
+	lines := append([]string{fmt.Sprintf("Queued %d, skipped %d:", len(queued), len(failed))}, queued...)
+	return strings.Join(append(lines, failed...), "\n")
+}
+
+// reply sends a silent reply to the message.
+func (b *Bot) reply(msg *tb.Message, text string) {
+	b.telegram.Send(msg.Chat, text, tb.Silent, &tb.SendOptions{
+		ReplyTo:               msg,
+		DisableWebPagePreview: true,
+	})
+}
+
+// anywayTTL is how long probable reposts can be queued anyway.
+const anywayTTL = time.Hour
+
+// replyError replies to the message with the error that occurred when queuing
+// its medium. Probable reposts get a button for the sender of the message to
+// queue the medium anyway.
+func (b *Bot) replyError(msg *tb.Message, err error) {
+	repost, ok := err.(*room.ProbableRepostError)
+	if !ok {
+		b.reply(msg, errorText(err))
+		return
+	}
+	b.replyAnyway(msg, errorText(err), []*room.ProbableRepostError{repost}, []string{"Queue anyway"})
+}
+
+// replyAnyway replies to the message with the text and a button with the
+// label for each of the probable reposts, which lets the sender of the message
+// queue it anyway. Buttons are removed once used and expire after the
+// anywayTTL.
+func (b *Bot) replyAnyway(msg *tb.Message, text string, reposts []*room.ProbableRepostError, labels []string) {
+	anyway := tb.InlineButton{Unique: "anyway" + randomID()}
+	var l sync.Mutex // guards pending
+	pending := make(map[int]bool, len(reposts))
+	for i := range reposts {
+		pending[i] = true
+	}
+	// markup returns the buttons of the pending reposts. The caller must hold
+	// the lock.
+	markup := func() *tb.ReplyMarkup {
+		var keyboard [][]tb.InlineButton
+		for i := range reposts {
+			if pending[i] {
+				keyboard = append(keyboard, []tb.InlineButton{{
+					Unique: anyway.Unique,
+					Text:   labels[i],
+					Data:   strconv.Itoa(i),
+				}})
 			}
-			b.telegram.Send(msg.Chat, resp, tb.Silent, &tb.SendOptions{
-				ReplyTo: msg,
-			})
-			log.Printf("could not queue medium: %s", err)
+		}
+		return &tb.ReplyMarkup{InlineKeyboard: keyboard}
+	}
+	sendOpt := &tb.SendOptions{
+		ReplyTo:               msg,
+		DisableWebPagePreview: true,
+		ReplyMarkup:           markup(),
+	}
+	// forget forgets the metadata of the reposts, in case they are uploads
+	forget := func(reposts ...*room.ProbableRepostError) {
+		for _, repost := range reposts {
+			b.uploads.Delete(fmt.Sprint(repost.Medium.ID()))
+		}
+	}
+	warning, err := b.telegram.Send(msg.Chat, text, tb.Silent, sendOpt)
+	if err != nil {
+		log.Printf("could not warn about probable reposts: %s", err)
+		forget(reposts...)
+		return
+	}
+
+	// update removes the buttons that are not pending anymore and cleans up
+	// once none is left. The caller must hold the lock.
+	update := func() {
+		sendOpt.ReplyMarkup = markup()
+		if len(pending) == 0 {
+			b.handleButton(anyway.Unique, nil)
+			sendOpt.ReplyMarkup = nil
+		}
+		b.telegram.Edit(warning, text, sendOpt)
+	}
+	// use removes the button of the repost. It returns false if there was no
+	// button.
+	use := func(i int) bool {
+		l.Lock()
+		defer l.Unlock()
+		if !pending[i] {
+			return false
+		}
+		delete(pending, i)
+		update()
+		return true
+	}
+	// expire removes all buttons
+	expire := func() {
+		l.Lock()
+		defer l.Unlock()
+		if len(pending) == 0 {
+			return
+		}
+		for i := range pending {
+			forget(reposts[i])
+			delete(pending, i)
+		}
+		update()
+	}
+	b.handleButton(anyway.Unique, func(c *tb.Callback) {
+		if c.Sender.ID != msg.Sender.ID {
+			b.telegram.Respond(c, &tb.CallbackResponse{Text: "Not your link!"})
+			return
+		}
+		i, err := strconv.Atoi(c.Data)
+		if err != nil || i < 0 || i >= len(reposts) || !use(i) {
+			b.telegram.Respond(c, &tb.CallbackResponse{Text: "Too late!"})
 			return
 		}
+		b.telegram.Respond(c, &tb.CallbackResponse{})
 
-		// show vote buttons
-		mID := strconv.FormatInt(rand.Int63(), 36)
-		upvote := tb.InlineButton{Unique: "upvote" + mID, Text: "❤️"}
-		resetvote := tb.InlineButton{Unique: "resetvote" + mID, Text: "🤷"}
-		downvote := tb.InlineButton{Unique: "downvote" + mID, Text: "💩"}
-		sendOpt := &tb.SendOptions{
-			ReplyTo: msg,
-			ReplyMarkup: &tb.ReplyMarkup{
-				InlineKeyboard: [][]tb.InlineButton{{downvote, resetvote, upvote}},
-			},
-		}
-		voteMsg, _ := b.telegram.Send(msg.Chat, "Queued (score: 0)", sendOpt)
-
-		// vote logic
-		vote := func(c *tb.Callback, gravity int) {
-			chat, user := b.seeUser(msg.Chat.ID, c.Sender.ID)
-			_ = chat.UserVotesMedium(user, m, gravity)
-			score, _ := chat.GetMediumScore(m)
-			b.telegram.Respond(c, &tb.CallbackResponse{Text: "Voted!"})
-			b.telegram.Edit(voteMsg, fmt.Sprintf("Queued (score: %d)", score), sendOpt)
-		}
-		b.telegram.Handle(&upvote, func(c *tb.Callback) { vote(c, +1) })
-		b.telegram.Handle(&resetvote, func(c *tb.Callback) { vote(c, 0) })
-		b.telegram.Handle(&downvote, func(c *tb.Callback) { vote(c, -1) })
-
-		// create clean up func
-		mediumCtx := &mediumContext{
-			originalMessage: msg,
-			cleanUp: func(why string) {
-				chat.Lock()
-				defer chat.Unlock()
-				sendOpt.ReplyMarkup = nil
-				b.telegram.Edit(voteMsg, why, sendOpt)
-				// release resources so that the gc can do the rest
-				b.telegram.Handle(&upvote, nil)
-				b.telegram.Handle(&resetvote, nil)
-				b.telegram.Handle(&downvote, nil)
-				delete(chat.media, m)
-			},
-		}
-		chat.media[m] = mediumCtx
-
-		// clean up when played/removed
-		go func() {
-			reason := "removed"
-			if nil == <-played {
-				reason = "played"
-			}
-			mediumCtx.cleanUp(reason)
-		}()
+		chat, user := b.seeUser(msg.Chat.ID, c.Sender)
+		defer forget(reposts[i])
+		if _, err := b.queueMedium(chat, user, msg, reposts[i].Medium, true); err != nil {
+			b.reply(msg, errorText(err))
+		}
 	})
+	time.AfterFunc(anywayTTL, expire)
+}
 
-	b.telegram.Start()
+// showVoteButtons replies to the message with vote buttons for the queued
+// medium, updates them while the medium is playing and cleans them up once it
+// ended. The caller must hold the lock of the chat.
+func (b *Bot) showVoteButtons(chat *chat, msg *tb.Message, m medium.Medium, states <-chan room.State) {
+	buttons := newVoteButtons()
+	sendOpt := &tb.SendOptions{ReplyTo: msg, ReplyMarkup: buttons.markup(chat, m)}
+	voteMsg, err := b.telegram.Send(msg.Chat, voteText(chat, m), sendOpt)
+	if err != nil {
+		log.Printf("could not show vote buttons of %s: %s", medium.URI(m), err)
+		go b.saveStates(chat, states)
+		return
+	}
+	b.handleVoteButtons(chat, msg, voteMsg, buttons, m, states)
+}
+
+// saveStates saves the chat whenever the state of a medium without vote
+// buttons changes.
+func (b *Bot) saveStates(chat *chat, states <-chan room.State) {
+	for range states {
+		b.changed(chat)
+	}
+}
+
+// handleVoteButtons handles the buttons of the vote message of the medium,
+// which replies to the message. The caller must hold the lock of the chat.
+func (b *Bot) handleVoteButtons(chat *chat, msg, voteMsg *tb.Message, buttons *voteButtons, m medium.Medium, states <-chan room.State) {
+	update := func() {
+		b.telegram.Edit(voteMsg, voteText(chat, m), buttons.markup(chat, m))
+	}
+
+	// vote logic
+	vote := func(c *tb.Callback, gravity int) {
+		chat, user := b.seeUser(msg.Chat.ID, c.Sender)
+		_ = chat.UserVotesMedium(user, m, gravity)
+		b.telegram.Respond(c, &tb.CallbackResponse{Text: "Voted!"})
+		update()
+		b.changed(chat)
+	}
+	b.handleButton(buttons.upvote.Unique, func(c *tb.Callback) { vote(c, +1) })
+	b.handleButton(buttons.resetvote.Unique, func(c *tb.Callback) { vote(c, 0) })
+	b.handleButton(buttons.downvote.Unique, func(c *tb.Callback) { vote(c, -1) })
+	b.handleButton(buttons.skip.Unique, func(c *tb.Callback) {
+		chat, user := b.seeUser(msg.Chat.ID, c.Sender)
+		skipped, err := chat.UserVotesSkip(user, m)
+		switch {
+		case err == room.ErrSkippingDisabled:
+			b.telegram.Respond(c, &tb.CallbackResponse{Text: "Skipping is disabled"})
+		case err != nil:
+			b.telegram.Respond(c, &tb.CallbackResponse{Text: "Too late!"})
+		case skipped:
+			b.telegram.Respond(c, &tb.CallbackResponse{Text: "Skipped!"})
+		default:
+			b.telegram.Respond(c, &tb.CallbackResponse{Text: "Voted to skip!"})
+			update()
+			b.changed(chat)
+		}
+	})
+
+	// create clean up func
+	mediumCtx := &mediumContext{
+		originalMessage: msg,
+		voteMessage:     voteMsg,
+		buttons:         buttons,
+		cleanUp: func(why string) {
+			chat.Lock()
+			defer chat.Unlock()
+			b.telegram.Edit(voteMsg, why, &tb.SendOptions{ReplyTo: msg})
+			// release resources so that the gc can do the rest
+			b.handleButton(buttons.upvote.Unique, nil)
+			b.handleButton(buttons.resetvote.Unique, nil)
+			b.handleButton(buttons.downvote.Unique, nil)
+			b.handleButton(buttons.skip.Unique, nil)
+			delete(chat.media, m)
+		},
+	}
+	chat.media[m] = mediumCtx
+	b.changed(chat)
+
+	// show when playing and clean up when ended
+	go func() {
+		for state := range states {
+			if state.Terminal() {
+				mediumCtx.cleanUp(endText(state))
+			} else {
+				update()
+			}
+			b.changed(chat)
+		}
+	}()
+}
+
+// voteButtons are the buttons of a vote message. Their unique names end with
+// the same id, so that they can be handled again after a restart.
+type voteButtons struct {
+	id                                string
+	upvote, resetvote, downvote, skip tb.InlineButton
+}
+
+// newVoteButtons returns vote buttons with a random id.
+func newVoteButtons() *voteButtons {
+	return voteButtonsOf(randomID())
+}
+
+// voteButtonsOf returns the vote buttons with the id.
+func voteButtonsOf(id string) *voteButtons {
+	return &voteButtons{
+		id:        id,
+		upvote:    tb.InlineButton{Unique: "upvote" + id, Text: "❤️"},
+		resetvote: tb.InlineButton{Unique: "resetvote" + id, Text: "🤷"},
+		downvote:  tb.InlineButton{Unique: "downvote" + id, Text: "💩"},
+		skip:      tb.InlineButton{Unique: "skip" + id, Text: "⏭"},
+	}
+}
+
+// markup returns the buttons of the medium. The skip button is only shown
+// while the medium plays.
+func (v *voteButtons) markup(chat *chat, m medium.Medium) *tb.ReplyMarkup {
+	keyboard := [][]tb.InlineButton{{v.downvote, v.resetvote, v.upvote}}
+	if current, ok := chat.Current(); ok && current.Medium == m && current.SkipsNeeded > 0 {
+		keyboard = append(keyboard, []tb.InlineButton{v.skip})
+	}
+	return &tb.ReplyMarkup{InlineKeyboard: keyboard}
+}
+
+// voteText returns the text of the vote message of the medium.
+func voteText(chat *chat, m medium.Medium) string {
+	label, skips := "Queued", ""
+	if current, ok := chat.Current(); ok && current.Medium == m {
+		label = "▶️ Now playing"
+		if current.SkipsNeeded > 0 {
+			skips = fmt.Sprintf(", skip: %d/%d", current.SkipVotes, current.SkipsNeeded)
+		}
+	}
+	if md, _ := chat.GetMediumMetadata(m); md.String() != "" {
+		label += ": " + md.String()
+	}
+	score, _, _ := chat.GetMediumScore(m)
+	return fmt.Sprintf("%s (score: %d%s)", label, score, skips)
+}
+
+// endText returns the text of the vote message of a medium that ended in the
+// state.
+func endText(state room.State) string {
+	switch state {
+	case room.Finished:
+		return "played"
+	case room.Failed:
+		return "could not be played"
+	}
+	return state.String()
+}
+
+// ExpandCollections enables queuing all media of collections like playlists.
+// At most max media are queued per collection. It must be called before the
+// bot is started.
+func (b *Bot) ExpandCollections(resolver medium.CollectionResolver, max int) {
+	b.collectionResolver = resolver
+	b.maxCollectionSize = max
+}
+
+// SniffFiles enables asking the servers of links to unknown hosts whether
+// they serve an audio or video file. It must be called before the bot is
+// started.
+func (b *Bot) SniffFiles() {
+	b.sniffFiles = true
+}
+
+// DetectProbableReposts enables warning about media that are probably the
+// same song as a queued medium of another provider. It must be called before
+// the bot is started.
+func (b *Bot) DetectProbableReposts() {
+	b.probableReposts = true
+}
+
+// Policy sets the rules that media must follow in every chat, unless the
+// policy of a chat was changed with /policy. It must be called before the bot
+// is started.
+func (b *Bot) Policy(rules ...room.Rule) {
+	b.policy = rules
+}
+
+// Limits sets how many media each user can queue in every chat. It must be
+// called before the bot is started.
+func (b *Bot) Limits(limits room.Limits) {
+	b.limits = limits
+}
+
+// Aging sets how votes lose weight and media gain score while they wait in
+// every chat. It must be called before the bot is started.
+func (b *Bot) Aging(aging room.Aging) {
+	b.aging = aging
+}
+
+// SkipThreshold sets how many votes skip the playing medium in every chat.
+// It must be called before the bot is started.
+func (b *Bot) SkipThreshold(threshold room.SkipThreshold) {
+	b.skipThreshold = threshold
+}
+
+// RepostCooldown sets how long played media cannot be queued again in every
+// chat. It must be called before the bot is started.
+func (b *Bot) RepostCooldown(cooldown time.Duration) {
+	b.repostCooldown = cooldown
+}
+
+// UnwrapShortLinks enables following the redirects of short links before
+// their media are loaded. It must be called before the bot is started.
+func (b *Bot) UnwrapShortLinks(unwrapper *medium.Unwrapper) {
+	b.unwrapper = unwrapper
+}
+
+// Search enables the /q command and inline queries to search media with the
+// backend. It must be called before the bot is started.
+func (b *Bot) Search(backend search.Backend) {
+	b.searchBackend = backend
+	b.searches = search.NewSearches(backend, 5, time.Hour)
+}
+
+// ResolveMetadata enables resolving the metadata of queued media, which is
+// then shown in messages. It must be called before the bot is started.
+func (b *Bot) ResolveMetadata(resolver metadata.Resolver) {
+	b.metadataResolver = resolver
+}
+
+// FileURL returns the url to download the telegram file from. It contains the
+// bot token.
+func (b *Bot) FileURL(fileID string) (string, error) {
+	return b.telegram.FileURLByID(fileID)
 }
 
 // Room returns the room with the given telegram chat id.
@@ -209,35 +1119,110 @@ func (b *Bot) seeChat(chatID int64) *chat {
 	if chat, ok := b.chats[chatID]; ok {
 		return chat
 	}
-	b.chats[chatID] = &chat{
-		Room:  room.New(),
-		users: make(map[int]*user),
-		media: make(map[medium.Medium]*mediumContext),
+	chat := &chat{
+		Room:    room.New(),
+		id:      chatID,
+		users:   make(map[int]*user),
+		media:   make(map[medium.Medium]*mediumContext),
+		changed: make(chan struct{}, 1),
+	}
+	if b.collectionResolver != nil {
+		chat.SetCollectionResolver(b.collectionResolver, b.maxCollectionSize)
+	}
+	chat.SetDetectProbableReposts(b.probableReposts)
+	chat.SetPolicy(b.policy...)
+	chat.SetLimits(b.limits)
+	chat.SetAging(b.aging)
+	chat.SetSkipThreshold(b.skipThreshold)
+	chat.SetRepostCooldown(b.repostCooldown)
+	if b.metadataResolver != nil {
+		chat.SetMetadataResolver(metadata.Multi(&b.uploads, b.metadataResolver))
+	} else {
+		chat.SetMetadataResolver(&b.uploads)
+	}
+	b.chats[chatID] = chat
+	select {
+	case <-b.stopped:
+	default:
+		if b.storage != nil {
+			b.savers.Add(1)
+			go b.saveChanges(chat) // once something changed
+		}
 	}
-	return b.chats[chatID]
+	return chat
 }
 
-func (b *Bot) seeUser(chatID int64, userID int) (*chat, *user) {
+func (b *Bot) seeUser(chatID int64, sender *tb.User) (*chat, *user) {
 	chat := b.seeChat(chatID)
 	chat.Lock()
 	defer chat.Unlock()
-	if user, ok := chat.users[userID]; ok {
+	if user, ok := chat.users[sender.ID]; ok {
+		if user.Name != sender.FirstName { // might have changed
+			user.Name = sender.FirstName
+			b.changed(chat)
+		}
 		return chat, user
 	}
-	user := &user{ID: userID}
-	chat.users[userID] = user
+	user := &user{ID: sender.ID, Name: sender.FirstName}
+	chat.users[sender.ID] = user
 	chat.UserJoins(user)
+	b.changed(chat)
 	return chat, user
 }
 
-func getFirstURL(m *tb.Message) string {
-	if strings.HasPrefix(m.Text, "https://") || strings.HasPrefix(m.Text, "http://") {
-		return m.Text
+// title returns the title of the medium or its url if the title is unknown.
+func (c *chat) title(m medium.Medium) string {
+	if md, _ := c.GetMediumMetadata(m); md.String() != "" {
+		return md.String()
+	}
+	if url := medium.URL(m); url != "" {
+		return url
 	}
-	for _, entity := range m.Entities {
-		if entity.URL != "" {
-			return entity.URL
+	return medium.URI(m)
+}
+
+// getURLs returns the urls of all url and text link entities of the text or
+// caption of the message, without duplicates.
+func getURLs(m *tb.Message) []string {
+	text, entities := m.Text, m.Entities
+	if text == "" {
+		text, entities = m.Caption, m.CaptionEntities
+	}
+	// entity offsets are in UTF-16 code units
+	utf16Text := utf16.Encode([]rune(text))
+
+	var urls []string
+	seen := make(map[string]bool)
+	for _, entity := range entities {
+		var url string
+		switch entity.Type {
+		case tb.EntityTextLink:
+			url = entity.URL
+		case tb.EntityURL:
+			if entity.Offset < 0 || entity.Length < 0 || entity.Offset+entity.Length > len(utf16Text) {
+				continue
+			}
+			url = string(utf16.Decode(utf16Text[entity.Offset : entity.Offset+entity.Length]))
+			if !strings.Contains(url, "://") {
+				url = "http://" + url
+			}
+		default:
+			continue
 		}
+		if !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// randomID returns a random id for the names of buttons. It is unlikely to be
+// used by buttons of restored chats or of an earlier run.
+func randomID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
 	}
-	return "http://" + m.Text
+	return strconv.FormatUint(binary.LittleEndian.Uint64(b[:]), 36)
 }
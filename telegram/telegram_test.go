@@ -0,0 +1,764 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Teelevision/telegram-duebelwein-bot/medium"
+	"github.com/Teelevision/telegram-duebelwein-bot/medium/metadata"
+	"github.com/Teelevision/telegram-duebelwein-bot/room"
+	"github.com/Teelevision/telegram-duebelwein-bot/search"
+	"github.com/Teelevision/telegram-duebelwein-bot/storage"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+func TestGetURLs(t *testing.T) {
+	testCases := []struct {
+		desc string
+		msg  *tb.Message
+		urls []string
+	}{
+		{
+			desc: "no entities",
+			msg:  &tb.Message{Text: "https://youtu.be/YgGzAKP_HuM"},
+			urls: nil,
+		}, {
+			desc: "url entities after multi byte characters",
+			msg: &tb.Message{
+				Text: "🔥 https://youtu.be/YgGzAKP_HuM and ümlaut youtu.be/cNtZAbq2Ig4",
+				Entities: []tb.MessageEntity{
+					{Type: tb.EntityURL, Offset: 3, Length: 28},
+					{Type: tb.EntityBold, Offset: 32, Length: 3},
+					{Type: tb.EntityURL, Offset: 43, Length: 20},
+				},
+			},
+			urls: []string{"https://youtu.be/YgGzAKP_HuM", "http://youtu.be/cNtZAbq2Ig4"},
+		}, {
+			desc: "text links and duplicates",
+			msg: &tb.Message{
+				Text: "this and that and https://youtu.be/YgGzAKP_HuM",
+				Entities: []tb.MessageEntity{
+					{Type: tb.EntityTextLink, Offset: 0, Length: 4, URL: "https://youtu.be/YgGzAKP_HuM"},
+					{Type: tb.EntityTextLink, Offset: 9, Length: 4, URL: "https://soundcloud.com/fu-ggbeats/sludge"},
+					{Type: tb.EntityURL, Offset: 18, Length: 28},
+				},
+			},
+			urls: []string{"https://youtu.be/YgGzAKP_HuM", "https://soundcloud.com/fu-ggbeats/sludge"},
+		}, {
+			desc: "caption",
+			msg: &tb.Message{
+				Caption: "look https://youtu.be/YgGzAKP_HuM",
+				CaptionEntities: []tb.MessageEntity{
+					{Type: tb.EntityURL, Offset: 5, Length: 28},
+				},
+			},
+			urls: []string{"https://youtu.be/YgGzAKP_HuM"},
+		}, {
+			desc: "entity out of range",
+			msg: &tb.Message{
+				Text:     "short",
+				Entities: []tb.MessageEntity{{Type: tb.EntityURL, Offset: 3, Length: 28}},
+			},
+			urls: nil,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if urls := getURLs(tC.msg); !reflect.DeepEqual(urls, tC.urls) {
+				t.Errorf("expected %q, got %q", tC.urls, urls)
+			}
+		})
+	}
+}
+
+func TestInlineResults(t *testing.T) {
+	video, _ := medium.New("https://youtu.be/YgGzAKP_HuM")
+	track, _ := medium.New("https://soundcloud.com/fu-ggbeats/sludge")
+	upload, _ := medium.NewTelegramFile("AwADBAADbXXXXXXXXXXXGBdhD2l6_XX")
+	results := []search.Result{
+		{Medium: medium.NewClip(video, 95*time.Second, 0), Metadata: metadata.Metadata{Title: "Primo Victoria", Artist: "Sabaton"}},
+		{Medium: upload, Metadata: metadata.Metadata{Title: "Voice note"}},
+		{Medium: video, Metadata: metadata.Metadata{Title: "Primo Victoria"}},
+		{Medium: track},
+	}
+	want := tb.Results{
+		&tb.ArticleResult{
+			ResultBase:  tb.ResultBase{ID: "0"},
+			Title:       "Sabaton - Primo Victoria",
+			Text:        "https://www.youtube.com/watch?v=YgGzAKP_HuM&t=95s",
+			Description: "https://www.youtube.com/watch?v=YgGzAKP_HuM&t=95s",
+		},
+		&tb.ArticleResult{
+			ResultBase:  tb.ResultBase{ID: "1"},
+			Title:       "https://soundcloud.com/fu-ggbeats/sludge",
+			Text:        "https://soundcloud.com/fu-ggbeats/sludge",
+			Description: "https://soundcloud.com/fu-ggbeats/sludge",
+		},
+	}
+	inline := inlineResults(results)
+	if len(inline) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(inline))
+	}
+	for i := range inline {
+		if !reflect.DeepEqual(inline[i], want[i]) {
+			t.Errorf("expected result #%d to be %+v, got %+v", i, want[i], inline[i])
+		}
+	}
+}
+
+func TestQueued(t *testing.T) {
+	video, _ := medium.New("https://youtu.be/YgGzAKP_HuM")
+	track, _ := medium.New("https://soundcloud.com/fu-ggbeats/sludge")
+	b := &Bot{chats: make(map[int64]*chat)}
+	joakim := &tb.User{ID: 1, FirstName: "Joakim"}
+	c1, u1 := b.seeUser(-1001, joakim)
+	c2, u2 := b.seeUser(-1002, joakim)
+	c1.UserQueuesMedium(u1, video)
+	for _, m := range []medium.Medium{medium.NewClip(video, 95*time.Second, 0), track} {
+		c2.UserQueuesMedium(u2, m)
+		c2.MediumDispatched(m)
+		c2.MediumPlayed(m)
+	}
+	c2.UserQueuesMedium(u2, track)
+
+	results := b.queued(joakim.ID, "")
+	if len(results) != 2 || !medium.Identical(results[0].Medium, video) && !medium.Identical(results[1].Medium, video) {
+		t.Fatalf("expected the video and the track once each, got %+v", results)
+	}
+	if results := b.queued(2, ""); len(results) != 0 {
+		t.Errorf("expected no results for other users, got %+v", results)
+	}
+}
+
+func TestHistoryText(t *testing.T) {
+	now := time.Date(2020, 2, 2, 20, 0, 0, 0, time.UTC)
+	video, _ := medium.New("https://youtu.be/YgGzAKP_HuM")
+	track, _ := medium.New("https://soundcloud.com/fu-ggbeats/sludge")
+	plays := []room.Play{
+		{Medium: video, Metadata: metadata.Metadata{Title: "Primo Victoria", Artist: "Sabaton"}, User: &user{Name: "Joakim"}, Score: 2, PlayedAt: now.Add(-5 * time.Minute)},
+		{Medium: track, Score: -1, State: room.Skipped, PlayedAt: now.Add(-25 * time.Hour)},
+	}
+	want := "Recently played:\n" +
+		"• Sabaton - Primo Victoria (score: 2) by Joakim, 5m ago\n" +
+		"• https://soundcloud.com/fu-ggbeats/sludge (score: -1) ⏭, 1d ago"
+	if text := historyText(plays, now); text != want {
+		t.Errorf("expected %q, got %q", want, text)
+	}
+	if text := historyText(nil, now); text != "Nothing was played, yet." {
+		t.Errorf("expected nothing to be played, got %q", text)
+	}
+}
+
+func TestRestoreChat(t *testing.T) {
+	b := &Bot{chats: make(map[int64]*chat), storage: storage.NewMemory()}
+	c, joakim := b.seeUser(-1001, &tb.User{ID: 1, FirstName: "Joakim"})
+	_, serj := b.seeUser(-1001, &tb.User{ID: 2, FirstName: "Serj"})
+	c.SetSkipThreshold(room.SkipThreshold{Votes: 2})
+	var media []medium.Medium
+	for _, link := range []string{
+		"https://youtu.be/YgGzAKP_HuM?t=95",
+		"https://soundcloud.com/fu-ggbeats/sludge",
+		"https://www.youtube.com/watch?v=cNtZAbq2Ig4",
+	} {
+		m, _ := medium.New(link)
+		if _, err := c.UserQueuesMedium(joakim, m); err != nil {
+			t.Fatalf("did not expect error, got %q", err)
+		}
+		media = append(media, m)
+	}
+	c.UserVotesMedium(serj, media[1], +1)
+	c.MediumDispatched(media[0])
+	c.MediumPlayed(media[0])
+	c.MediumDispatched(media[2])
+	c.UserVotesSkip(serj, media[2])
+
+	c.RLock()
+	want := storedChat(c)
+	c.RUnlock()
+	if len(want.Users) != 2 || len(want.Media) != 2 || len(want.History) != 1 || want.Users[1].Name != "Serj" {
+		t.Fatalf("expected 2 users, 2 media and 1 play, got %+v", want)
+	}
+
+	restored := &Bot{chats: make(map[int64]*chat), storage: storage.NewMemory()}
+	restored.restoreChat(want)
+	c = restored.chats[-1001]
+	c.RLock()
+	got := storedChat(c)
+	c.RUnlock()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if q := c.Queue(); len(q) != 1 || q[0].Score != 1 || q[0].User != c.users[1] {
+		t.Errorf("expected the soundcloud track with a vote by serj, got %+v", q)
+	}
+	if current, ok := c.Current(); !ok || current.SkipVotes != 1 {
+		t.Errorf("expected the current medium to have a skip vote, got %+v", current)
+	}
+}
+
+func TestRestoreChat_buttons(t *testing.T) {
+	telegram, calls, stop := fakeTelegram(t)
+	defer stop()
+	b := &Bot{
+		telegram:      telegram,
+		chats:         make(map[int64]*chat),
+		skipThreshold: room.SkipThreshold{Votes: 1},
+	}
+	telegram.Handle(tb.OnCallback, b.callback)
+	now := time.Now()
+	primoVictoria, _ := medium.New("https://youtu.be/YgGzAKP_HuM")
+	nightWitches, _ := medium.New("https://youtu.be/cNtZAbq2Ig4")
+	b.restoreChat(storage.Chat{
+		ID:    -1001,
+		Users: []storage.User{{ID: 1, Name: "Joakim"}, {ID: 2, Name: "Serj"}},
+		Media: []storage.Medium{{
+			Medium:       medium.JSON{Medium: primoVictoria},
+			User:         1,
+			AddedAt:      now.Add(-time.Minute),
+			State:        room.Playing,
+			DispatchedAt: now,
+			StartedAt:    now,
+			Message:      22,
+			ReplyTo:      21,
+			Buttons:      "current",
+		}, {
+			Medium:  medium.JSON{Medium: nightWitches},
+			User:    1,
+			AddedAt: now,
+			State:   room.Queued,
+			Message: 12,
+			ReplyTo: 11,
+			Buttons: "queued",
+		}},
+	})
+	c := b.chats[-1001]
+	serj := &tb.User{ID: 2, FirstName: "Serj"}
+	press := func(data string) string {
+		t.Helper()
+		telegram.Updates <- tb.Update{Callback: &tb.Callback{ID: "1", Sender: serj, Data: "\f" + data}}
+		return expectCall(t, calls, "answerCallbackQuery")["text"].(string)
+	}
+
+	testCases := []struct {
+		desc  string
+		data  string
+		score int
+	}{
+		{desc: "upvote", data: "upvotequeued", score: 1},
+		{desc: "downvote", data: "downvotequeued", score: -1},
+		{desc: "reset", data: "resetvotequeued", score: 0},
+	}
+	for _, tC := range testCases {
+		if text := press(tC.data); text != "Voted!" {
+			t.Errorf("%s: expected the vote to be handled, got %q", tC.desc, text)
+		}
+		if score, _, _ := c.GetMediumScore(nightWitches); score != tC.score {
+			t.Errorf("%s: expected score %d, got %d", tC.desc, tC.score, score)
+		}
+	}
+	if text := press("skipcurrent"); text != "Skipped!" {
+		t.Errorf("expected the current medium to be skipped, got %q", text)
+	}
+	if current, ok := c.Current(); ok && current.Medium == primoVictoria {
+		t.Errorf("expected primo victoria to be skipped, got %+v", current)
+	}
+}
+
+func TestStop(t *testing.T) {
+	telegram, calls, stop := fakeTelegram(t)
+	defer stop()
+	store := &recordingStorage{}
+	b := &Bot{telegram: telegram, chats: make(map[int64]*chat), storage: store, stopped: make(chan struct{})}
+	msg := &tb.Message{ID: 10, Chat: &tb.Chat{ID: -1001, Type: tb.ChatGroup}, Sender: &tb.User{ID: 1}}
+	c, user := b.seeUser(msg.Chat.ID, msg.Sender)
+	m, _ := medium.New("https://youtu.be/cNtZAbq2Ig4")
+	if _, err := b.queueMedium(c, user, msg, m, false); err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	expectCall(t, calls, "sendMessage")
+
+	b.Stop()
+	go telegram.Start() // so that it can be stopped again
+	saved := store.saved()
+	if len(saved) == 0 || len(saved[len(saved)-1].Media) != 1 {
+		t.Fatalf("expected the queued medium to be saved last, got %+v", saved)
+	}
+	// the chat is not saved anymore once the bot stopped
+	b.changed(c)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(store.saved()); n != len(saved) {
+		t.Errorf("expected %d saves, got %d", len(saved), n)
+	}
+}
+
+// recordingStorage records the saved chats.
+type recordingStorage struct {
+	l     sync.Mutex
+	chats []storage.Chat
+}
+
+func (s *recordingStorage) Load() ([]storage.Chat, error) {
+	return nil, nil
+}
+
+func (s *recordingStorage) Save(chat storage.Chat) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.chats = append(s.chats, chat)
+	return nil
+}
+
+func (s *recordingStorage) saved() []storage.Chat {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return append([]storage.Chat(nil), s.chats...)
+}
+
+func TestChangePolicy(t *testing.T) {
+	b := &Bot{chats: make(map[int64]*chat), policy: []room.Rule{room.MaxDuration(time.Hour)}}
+	c := b.seeChat(-1001)
+	testCases := []struct {
+		desc   string
+		change string
+		want   string
+	}{
+		{desc: "list", change: " ", want: "Media must be:\n• at most 1:00:00 long"},
+		{desc: "replace", change: "max 10:00", want: "Media must be:\n• at most 10:00 long"},
+		{desc: "add", change: "artist Nickelback", want: "Media must be:\n• at most 10:00 long\n• not by Nickelback"},
+		{desc: "merge", change: "artist Cow Channel", want: "Media must be:\n• at most 10:00 long\n• not by Nickelback, Cow Channel"},
+		{desc: "invalid", change: "max forever", want: "invalid duration: forever\n" + policyUsage},
+		{desc: "known", change: "known", want: "Media must be:\n• at most 10:00 long\n• not by Nickelback, Cow Channel\n• of known duration"},
+	}
+	for _, tC := range testCases {
+		if got := b.changePolicy(c, tC.change); got != tC.want {
+			t.Fatalf("%s: expected %q, got %q", tC.desc, tC.want, got)
+		}
+	}
+
+	// the policy is kept across restarts
+	c.RLock()
+	stored := storedChat(c)
+	c.RUnlock()
+	want := []string{"max 10m0s", "artist Nickelback", "artist Cow Channel", "known"}
+	if !reflect.DeepEqual(stored.Policy, want) {
+		t.Fatalf("expected policy %q to be stored, got %q", want, stored.Policy)
+	}
+	restored := &Bot{chats: make(map[int64]*chat), policy: b.policy}
+	restored.restoreChat(stored)
+	if got := policyText(restored.chats[-1001].Policy()); got != policyText(c.Policy()) {
+		t.Errorf("expected the policy to be restored, got %q", got)
+	}
+
+	// until it is reset
+	if got := b.changePolicy(c, "reset"); got != "Media must be:\n• at most 1:00:00 long" {
+		t.Errorf("expected the policy of the bot, got %q", got)
+	}
+	c.RLock()
+	stored = storedChat(c)
+	c.RUnlock()
+	if stored.Policy != nil {
+		t.Errorf("expected no policy to be stored, got %q", stored.Policy)
+	}
+}
+
+func TestChangeOrdering(t *testing.T) {
+	b := &Bot{chats: make(map[int64]*chat)}
+	c := b.seeChat(-1001)
+	usage := "Usage: /order <fifo|roundrobin|score|shuffle> [max songs in a row]"
+	testCases := []struct {
+		desc   string
+		change string
+		want   string
+	}{
+		{desc: "show", change: " ", want: "The queue is ordered by score. " + usage},
+		{desc: "change", change: "FIFO", want: "The queue is now ordered by fifo"},
+		{desc: "unknown", change: "random", want: "unknown order: random\n" + usage},
+		{desc: "invalid number", change: "roundrobin two", want: "invalid number of songs in a row: two\n" + usage},
+		{desc: "zero", change: "roundrobin 0", want: "invalid number of songs in a row: 0\n" + usage},
+		{desc: "too many arguments", change: "roundrobin 2 3", want: "invalid order: roundrobin 2 3\n" + usage},
+		{desc: "unchanged by errors", change: "", want: "The queue is ordered by fifo. " + usage},
+		{desc: "round robin", change: "roundrobin 2", want: "The queue is now ordered by roundrobin 2"},
+	}
+	for _, tC := range testCases {
+		if got := b.changeOrdering(c, tC.change); got != tC.want {
+			t.Fatalf("%s: expected %q, got %q", tC.desc, tC.want, got)
+		}
+	}
+
+	// the ordering is kept across restarts
+	c.RLock()
+	stored := storedChat(c)
+	c.RUnlock()
+	if stored.Ordering != "roundrobin 2" {
+		t.Fatalf("expected the ordering to be stored, got %q", stored.Ordering)
+	}
+	restored := &Bot{chats: make(map[int64]*chat)}
+	restored.restoreChat(stored)
+	if got := restored.chats[-1001].Ordering(); got != (room.RoundRobin{MaxConsecutive: 2}) {
+		t.Errorf("expected the ordering to be restored, got %#v", got)
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	telegram, _, stop := fakeTelegram(t)
+	defer stop()
+	b := &Bot{telegram: telegram}
+	group := &tb.Chat{ID: -1001, Type: tb.ChatGroup}
+	if !b.isAdmin(group, &tb.User{ID: 1}) {
+		t.Error("expected user 1 to be an admin")
+	}
+	if b.isAdmin(group, &tb.User{ID: 2}) {
+		t.Error("expected user 2 not to be an admin")
+	}
+}
+
+func TestQueueSearch(t *testing.T) {
+	telegram, calls, stop := fakeTelegram(t)
+	defer stop()
+	nightWitches, _ := medium.New("https://youtu.be/cNtZAbq2Ig4")
+	primoVictoria, _ := medium.New("https://youtu.be/YgGzAKP_HuM")
+	backend := search.Static{
+		{Medium: nightWitches, Metadata: metadata.Metadata{Title: "Night Witches", Artist: "Sabaton"}},
+		{Medium: primoVictoria, Metadata: metadata.Metadata{Title: "Primo Victoria", Artist: "Sabaton"}},
+	}
+	b := &Bot{telegram: telegram, chats: make(map[int64]*chat), searches: search.NewSearches(backend, 5, time.Minute)}
+	telegram.Handle(tb.OnCallback, b.callback)
+	group := &tb.Chat{ID: -1001, Type: tb.ChatGroup}
+	joakim := &tb.User{ID: 1, FirstName: "Joakim"}
+	serj := &tb.User{ID: 2, FirstName: "Serj"}
+	b.seeUser(group.ID, serj)
+
+	// searchFor returns the callback data of the buttons to pick the results
+	// and to dismiss the search
+	searchFor := func(query string) []string {
+		t.Helper()
+		b.queueSearch(&tb.Message{ID: 10, Chat: group, Sender: joakim, Payload: query})
+		var markup tb.ReplyMarkup
+		json.Unmarshal([]byte(expectCall(t, calls, "sendMessage")["reply_markup"].(string)), &markup)
+		var data []string
+		for _, row := range markup.InlineKeyboard {
+			data = append(data, row[0].Data)
+		}
+		return data
+	}
+	press := func(sender *tb.User, data string) {
+		telegram.Updates <- tb.Update{Callback: &tb.Callback{ID: "1", Sender: sender, Data: data}}
+	}
+
+	t.Run("pick", func(t *testing.T) {
+		buttons := searchFor("sabaton")
+		if len(buttons) != 3 {
+			t.Fatalf("expected 2 results and a dismiss button, got %q", buttons)
+		}
+		press(serj, buttons[1])
+		if text := expectCall(t, calls, "answerCallbackQuery")["text"]; text != "Not your search!" {
+			t.Errorf("expected only joakim to pick, got %q", text)
+		}
+		press(joakim, buttons[1])
+		if text := expectCall(t, calls, "answerCallbackQuery")["text"]; text != "Picked!" {
+			t.Errorf("expected primo victoria to be picked, got %q", text)
+		}
+		if text := expectCall(t, calls, "editMessageText")["text"]; text != "Picked: Sabaton - Primo Victoria" {
+			t.Errorf("expected the results to be replaced, got %q", text)
+		}
+		expectCall(t, calls, "sendMessage") // vote buttons
+		if q := b.chats[group.ID].Queue(); len(q) != 1 || q[0].Medium != primoVictoria {
+			t.Errorf("expected primo victoria to be queued, got %+v", q)
+		}
+	})
+
+	t.Run("dismiss", func(t *testing.T) {
+		buttons := searchFor("night witches")
+		press(joakim, buttons[len(buttons)-1])
+		expectCall(t, calls, "answerCallbackQuery")
+		if text := expectCall(t, calls, "editMessageText")["text"]; text != "Dismissed" {
+			t.Errorf("expected the search to be dismissed, got %q", text)
+		}
+	})
+
+	t.Run("expire", func(t *testing.T) {
+		b.searches = search.NewSearches(backend, 5, 50*time.Millisecond)
+		buttons := searchFor("night witches")
+		if text := expectCall(t, calls, "editMessageText")["text"]; text != "Expired" {
+			t.Errorf("expected the search to expire, got %q", text)
+		}
+		// the buttons are not handled anymore
+		press(joakim, buttons[0])
+		select {
+		case c := <-calls:
+			t.Errorf("expected no answer after the search expired, got %+v", c)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+}
+
+func TestQueueSearch_sendFails(t *testing.T) {
+	telegram, calls, stop := fakeTelegram(t, "sendMessage")
+	defer stop()
+	nightWitches, _ := medium.New("https://youtu.be/cNtZAbq2Ig4")
+	backend := search.Static{{Medium: nightWitches, Metadata: metadata.Metadata{Title: "Night Witches", Artist: "Sabaton"}}}
+	b := &Bot{telegram: telegram, chats: make(map[int64]*chat), searches: search.NewSearches(backend, 5, 50*time.Millisecond)}
+	group := &tb.Chat{ID: -1001, Type: tb.ChatGroup}
+	b.queueSearch(&tb.Message{ID: 10, Chat: group, Sender: &tb.User{ID: 1}, Payload: "night witches"})
+	expectCall(t, calls, "sendMessage")
+	if n := len(b.callbacks.handlers); n != 0 {
+		t.Errorf("expected no buttons to be handled, got %d", n)
+	}
+	select {
+	case c := <-calls:
+		t.Errorf("expected no more calls, got %+v", c)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// apiCall is a request to the fake telegram bot api.
+type apiCall struct {
+	method string
+	params map[string]interface{}
+}
+
+// fakeTelegram returns a started bot that talks to a fake telegram bot api,
+// which reports every call but getMe to the channel. Calls of the failing
+// methods fail. Updates are sent to the Updates channel of the bot. The
+// returned func stops the bot.
+func fakeTelegram(t *testing.T, failing ...string) (*tb.Bot, <-chan apiCall, func()) {
+	calls := make(chan apiCall, 100)
+	var messageID int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := path.Base(r.URL.Path)
+		var params map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&params)
+		fails := false
+		for _, f := range failing {
+			fails = fails || f == method
+		}
+		switch {
+		case method == "getMe":
+			fmt.Fprint(w, `{"ok": true, "result": {"id": 1, "is_bot": true, "first_name": "Dübelweinbot"}}`)
+			return
+		case fails:
+			fmt.Fprint(w, `{"ok": false, "error_code": 429, "description": "Too Many Requests: retry after 5"}`)
+		case method == "answerCallbackQuery":
+			fmt.Fprint(w, `{"ok": true, "result": true}`)
+		case method == "getChatMember": // the user with id 1 is an admin
+			status := "member"
+			if params["user_id"] == "1" {
+				status = "administrator"
+			}
+			fmt.Fprintf(w, `{"ok": true, "result": {"user": {"id": %s}, "status": %q}}`, params["user_id"], status)
+		default:
+			fmt.Fprintf(w, `{"ok": true, "result": {"message_id": %d, "chat": {"id": -1001, "type": "group"}}}`, atomic.AddInt64(&messageID, 1))
+		}
+		calls <- apiCall{method, params}
+	}))
+	bot, err := tb.NewBot(tb.Settings{URL: server.URL, Token: "token", Poller: noPoller{}})
+	if err != nil {
+		server.Close()
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	go bot.Start()
+	return bot, calls, func() {
+		bot.Stop()
+		server.Close()
+	}
+}
+
+// expectCall waits for the next call of the method and returns its
+// parameters. Calls of other methods before it are skipped.
+func expectCall(t *testing.T, calls <-chan apiCall, method string) map[string]interface{} {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case c := <-calls:
+			if c.method == method {
+				return c.params
+			}
+		case <-timeout:
+			t.Fatalf("expected a call of %s", method)
+		}
+	}
+}
+
+// noPoller polls nothing. Updates are sent to the bot directly.
+type noPoller struct{}
+
+func (noPoller) Poll(_ *tb.Bot, _ chan tb.Update, stop chan struct{}) {
+	<-stop
+	close(stop)
+}
+
+func TestQueueMedium_sendFails(t *testing.T) {
+	telegram, calls, stop := fakeTelegram(t, "sendMessage")
+	defer stop()
+	b := &Bot{telegram: telegram, chats: make(map[int64]*chat)}
+	msg := &tb.Message{ID: 10, Chat: &tb.Chat{ID: -1001, Type: tb.ChatGroup}, Sender: &tb.User{ID: 1}}
+	c, user := b.seeUser(msg.Chat.ID, msg.Sender)
+	m, _ := medium.New("https://youtu.be/cNtZAbq2Ig4")
+	if _, err := b.queueMedium(c, user, msg, m, false); err != nil {
+		t.Fatalf("did not expect error, got %q", err)
+	}
+	expectCall(t, calls, "sendMessage")
+	if n := len(b.callbacks.handlers); n != 0 {
+		t.Errorf("expected no buttons to be handled, got %d", n)
+	}
+
+	// the medium plays without a vote message to update
+	c.MediumDispatched(m)
+	c.MediumPlaying(m)
+	c.MediumPlayed(m)
+	select {
+	case call := <-calls:
+		t.Errorf("expected no more calls, got %+v", call)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if h := c.History(); len(h) != 1 {
+		t.Errorf("expected the medium to be played, got %+v", h)
+	}
+}
+
+func TestQueueUpload(t *testing.T) {
+	telegram, calls, stop := fakeTelegram(t)
+	defer stop()
+	b := &Bot{telegram: telegram, chats: make(map[int64]*chat)}
+	group := &tb.Chat{ID: -1001, Type: tb.ChatGroup}
+	joakim := &tb.User{ID: 1, FirstName: "Joakim"}
+	upload := func() {
+		b.queueUpload(&tb.Message{ID: 10, Chat: group, Sender: joakim, Audio: &tb.Audio{
+			File:      tb.File{FileID: "CQADBAADsAADx2"},
+			Duration:  183,
+			Title:     "Night Witches",
+			Performer: "Sabaton",
+		}})
+	}
+	uploads := func() (n int) {
+		b.uploads.Range(func(_, _ interface{}) bool { n++; return true })
+		return n
+	}
+
+	upload()
+	if text := expectCall(t, calls, "sendMessage")["text"]; text != "Queued: Sabaton - Night Witches (3:03) (score: 0)" {
+		t.Errorf("expected the vote message of the upload, got %q", text)
+	}
+	if q := b.chats[-1001].Queue(); len(q) != 1 || q[0].Medium.ID() != "CQADBAADsAADx2" {
+		t.Fatalf("expected the upload to be queued, got %+v", q)
+	}
+	if md, _ := b.chats[-1001].GetMediumMetadata(b.chats[-1001].Queue()[0].Medium); md.Title != "Night Witches" || md.Duration != 183*time.Second {
+		t.Errorf("expected the metadata of the message, got %+v", md)
+	}
+	if n := uploads(); n != 0 {
+		t.Errorf("expected the metadata to be forgotten once queued, got %d uploads", n)
+	}
+
+	upload()
+	if text := expectCall(t, calls, "sendMessage")["text"]; text != errorText(room.ErrMediumAlreadyExists) {
+		t.Errorf("expected a duplicate, got %q", text)
+	}
+	if n := uploads(); n != 0 {
+		t.Errorf("expected the metadata to be forgotten if not queued, got %d uploads", n)
+	}
+}
+
+func TestQueueLinks_anyway(t *testing.T) {
+	telegram, calls, stop := fakeTelegram(t)
+	defer stop()
+	b := &Bot{
+		telegram:        telegram,
+		chats:           make(map[int64]*chat),
+		probableReposts: true,
+		metadataResolver: fakeMetadataResolver{
+			"youtube:cNtZAbq2Ig4":                       {Title: "Sabaton - Night Witches (Official Video)", Artist: "Nuclear Blast"},
+			"soundcloud:sabaton-official/night-witches": {Title: "Night Witches", Artist: "Sabaton"},
+			"youtube:YgGzAKP_HuM":                       {Title: "Primo Victoria", Artist: "Sabaton"},
+		},
+	}
+	telegram.Handle(tb.OnCallback, b.callback)
+	group := &tb.Chat{ID: -1001, Type: tb.ChatGroup}
+	joakim := &tb.User{ID: 1, FirstName: "Joakim"}
+	serj := &tb.User{ID: 2, FirstName: "Serj"}
+	links := func(sender *tb.User, urls ...string) *tb.Message {
+		msg := &tb.Message{ID: 10, Chat: group, Sender: sender}
+		for _, url := range urls {
+			msg.Entities = append(msg.Entities, tb.MessageEntity{Type: tb.EntityTextLink, Offset: len(msg.Text), Length: 4, URL: url})
+			msg.Text += "link "
+		}
+		return msg
+	}
+	b.queueLinks(links(serj, "https://www.youtube.com/watch?v=cNtZAbq2Ig4"))
+	expectCall(t, calls, "sendMessage") // vote buttons
+
+	b.queueLinks(links(joakim, "https://soundcloud.com/sabaton-official/night-witches", "https://youtu.be/YgGzAKP_HuM"))
+	expectCall(t, calls, "sendMessage") // vote buttons of primo victoria
+	params := expectCall(t, calls, "sendMessage")
+	if text := params["text"]; text != "Queued 1, skipped 1:\n✅ Sabaton - Primo Victoria\n❌ https://soundcloud.com/sabaton-official/night-witches: Probable repost of Sabaton - Night Witches (Official Video) (https://www.youtube.com/watch?v=cNtZAbq2Ig4)" {
+		t.Errorf("expected a summary, got %q", text)
+	}
+	var markup tb.ReplyMarkup
+	json.Unmarshal([]byte(params["reply_markup"].(string)), &markup)
+	if len(markup.InlineKeyboard) != 1 || markup.InlineKeyboard[0][0].Text != buttonText("Queue anyway: https://soundcloud.com/sabaton-official/night-witches") {
+		t.Fatalf("expected a button to queue the track anyway, got %+v", markup.InlineKeyboard)
+	}
+	data := markup.InlineKeyboard[0][0].Data
+	press := func(sender *tb.User, data string) {
+		telegram.Updates <- tb.Update{Callback: &tb.Callback{ID: "1", Sender: sender, Data: data}}
+	}
+
+	press(serj, data)
+	if text := expectCall(t, calls, "answerCallbackQuery")["text"]; text != "Not your link!" {
+		t.Errorf("expected only joakim to queue anyway, got %q", text)
+	}
+	for _, forged := range []string{"-1", "1", "x"} {
+		press(joakim, data[:strings.Index(data, "|")+1]+forged)
+		if text := expectCall(t, calls, "answerCallbackQuery")["text"]; text != "Too late!" {
+			t.Errorf("expected no button for data %q, got %q", forged, text)
+		}
+	}
+	press(joakim, data)
+	if params := expectCall(t, calls, "editMessageText"); params["reply_markup"] != nil {
+		t.Errorf("expected the button to be removed, got %q", params["reply_markup"])
+	}
+	expectCall(t, calls, "answerCallbackQuery")
+	expectCall(t, calls, "sendMessage") // vote buttons of the track
+	if q := b.chats[-1001].Queue(); len(q) != 3 {
+		t.Errorf("expected the track to be queued anyway, got %+v", q)
+	}
+}
+
+func TestReplyError_sendFails(t *testing.T) {
+	telegram, calls, stop := fakeTelegram(t, "sendMessage")
+	defer stop()
+	b := &Bot{telegram: telegram, chats: make(map[int64]*chat)}
+	upload, _ := medium.NewTelegramFile("CQADBAADsAADx2")
+	original, _ := medium.New("https://youtu.be/cNtZAbq2Ig4")
+	b.uploads.Store("CQADBAADsAADx2", metadata.Metadata{Title: "Night Witches", Artist: "Sabaton"})
+	msg := &tb.Message{ID: 10, Chat: &tb.Chat{ID: -1001, Type: tb.ChatGroup}, Sender: &tb.User{ID: 1}}
+	b.replyError(msg, &room.ProbableRepostError{Medium: upload, Original: original})
+	expectCall(t, calls, "sendMessage")
+	if n := len(b.callbacks.handlers); n != 0 {
+		t.Errorf("expected no buttons to be handled, got %d", n)
+	}
+	if _, ok := b.uploads.Load("CQADBAADsAADx2"); ok {
+		t.Errorf("expected the metadata of the upload to be forgotten")
+	}
+}
+
+// fakeMetadataResolver resolves the metadata of media by their uri.
+type fakeMetadataResolver map[string]metadata.Metadata
+
+func (r fakeMetadataResolver) Resolve(_ context.Context, m medium.Medium) (metadata.Metadata, error) {
+	md, ok := r[medium.URI(m)]
+	if !ok {
+		return metadata.Metadata{}, medium.ErrNotSupported
+	}
+	return md, nil
+}